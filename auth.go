@@ -1,15 +1,15 @@
 package hamr
 
 import (
+	"bytes"
+	"context"
+	"crypto"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"strings"
 	"time"
 
-	"github.com/gobackpack/jwt"
-	"github.com/google/uuid"
-
 	"github.com/semirm-dev/hamr/internal/env"
 	"github.com/semirm-dev/hamr/internal/str"
 	"github.com/semirm-dev/hamr/oauth"
@@ -22,9 +22,17 @@ import (
 
 type Auth[T any] struct {
 	conf                  *Config
-	storage               TokenStorage
+	storage               ContextTokenStorage
 	getUserDetailsByEmail GetUserDetailsFunc[T]
+	lookupUser            UserLookupFunc[T]
+	provisionUser         UserProvisionFunc[T]
+	identities            IdentityStore[T]
+	stateStore            oauth.StateStore
 	providers             []oauth.Provider
+	onRefreshTokenReuse   RefreshTokenReuseFunc[T]
+	keySet                *KeySet
+	newCustomClaims       NewCustomClaimsFunc
+	enrichClaims          ClaimsEnricherFunc[T]
 }
 
 type Config struct {
@@ -34,6 +42,31 @@ type Config struct {
 	AccessTokenExpiry  time.Duration
 	RefreshTokenSecret []byte
 	RefreshTokenExpiry time.Duration
+	// AccessTokenSigningKey will sign access tokens instead of AccessTokenSecret: *rsa.PrivateKey (RS256),
+	// *ecdsa.PrivateKey (ES256, ES384, ES512) or ed25519.PrivateKey (EdDSA). Resource servers only need its public key, see VerifyToken.
+	AccessTokenSigningKey crypto.Signer
+	// RefreshTokenSigningKey will sign refresh tokens instead of RefreshTokenSecret, same key types as AccessTokenSigningKey.
+	RefreshTokenSigningKey crypto.Signer
+	// TrustedProxies are IPs or CIDRs of proxies allowed to set X-Forwarded-For, used to get client IP of a session.
+	TrustedProxies []string
+	// MaxSessions is the limit of concurrent sessions per user, 0 means unlimited.
+	MaxSessions int
+	// SessionLimitPolicy is applied on login when user already has MaxSessions sessions.
+	SessionLimitPolicy SessionLimitPolicy
+	// IdleTimeout will expire session after given period of inactivity, 0 means disabled.
+	IdleTimeout time.Duration
+	// AbsoluteSessionLifetime will expire session after given period since login regardless of activity, 0 means disabled.
+	AbsoluteSessionLifetime time.Duration
+	// Issuer is written to iss claim and validated in access tokens, empty means not validated.
+	Issuer string
+	// Audience is written to aud claim, access token must contain at least one of them. Empty means not validated.
+	Audience []string
+	// Leeway is allowed clock skew when validating exp, nbf and iat claims.
+	Leeway time.Duration
+	// AllowedEmailDomains limits provisioning of new users to given email domains, empty means all domains are allowed.
+	AllowedEmailDomains []string
+	// DeniedEmailDomains are email domains which can not be provisioned. Existing users are not affected.
+	DeniedEmailDomains []string
 
 	basePath string
 	authPath string
@@ -43,21 +76,51 @@ type UserDetails[T any] struct {
 	ID T
 }
 
+// TokenStorage keeps tokens and sessions.
+// Load should return ErrNotFound for missing or expired keys, transient backend failures must be marked with Transient.
+// Errors not marked with Transient are treated as missing keys.
 type TokenStorage interface {
 	Store(item ...*Item) error
 	Load(key string) ([]byte, error)
 	Delete(key ...string) error
 }
 
+// ContextTokenStorage is context-aware TokenStorage, so storage calls can be cancelled and traced together with request.
+// TokenStorage implementations are adapted with StorageWithContext.
+type ContextTokenStorage interface {
+	StoreContext(ctx context.Context, item ...*Item) error
+	LoadContext(ctx context.Context, key string) ([]byte, error)
+	DeleteContext(ctx context.Context, key ...string) error
+}
+
 type Item struct {
-	Key        string
-	Value      interface{}
+	Key   string
+	Value interface{}
+	// Expiration is how long item lives in storage. Items with Expiration <= 0 never expire.
 	Expiration time.Duration
 }
 
+// SessionLimitPolicy defines what happens on login when user reached Config.MaxSessions.
+type SessionLimitPolicy int
+
+const (
+	// RejectNewSession will refuse login with SessionLimitError.
+	RejectNewSession SessionLimitPolicy = iota
+	// EvictOldestSession will revoke the oldest session of a user.
+	EvictOldestSession
+)
+
 type Option[T any] func(*Auth[T])
 type GetUserDetailsFunc[T any] func(email string) UserDetails[T]
 
+// UserLookupFunc finds user of OAuth login by provider profile: provider and external id, or email. Lookup errors abort the login,
+// ErrUserNotFound should be returned when user does not exist. It is called for every login without linked identity,
+// including empty and unverified emails: users must not be matched by email unless userInfo.EmailVerified is set.
+type UserLookupFunc[T any] func(ctx context.Context, userInfo *oauth.UserInfo) (UserDetails[T], error)
+
+// UserProvisionFunc creates user on first OAuth login from provider profile.
+type UserProvisionFunc[T any] func(ctx context.Context, userInfo *oauth.UserInfo) (UserDetails[T], error)
+
 // TokenDetails holds access and refresh token details.
 type TokenDetails struct {
 	AccessToken        string
@@ -66,13 +129,22 @@ type TokenDetails struct {
 	accessTokenExpiry  time.Duration
 	refreshTokenUuid   string
 	refreshTokenExpiry time.Duration
+
+	// RedirectTo is local path given as redirect_to on OAuth login start, empty for other token responses.
+	RedirectTo string
 }
 
 // TokenClaims contains required claims for authentication (sub + email). Validated in: validateClaims(claims TokenClaims).
 // These claims will be generated in access and refresh tokens
 type TokenClaims map[string]interface{}
 
+// New will set up Auth with storage without context support, it is adapted with StorageWithContext.
 func New[T any](storage TokenStorage, getUserDetails GetUserDetailsFunc[T], opts ...Option[T]) *Auth[T] {
+	return NewWithContextStorage(StorageWithContext(storage), getUserDetails, opts...)
+}
+
+// NewWithContextStorage will set up Auth with context-aware storage, used as is.
+func NewWithContextStorage[T any](storage ContextTokenStorage, getUserDetails GetUserDetailsFunc[T], opts ...Option[T]) *Auth[T] {
 	conf := NewConfig()
 	conf.Host = strings.Trim(conf.Host, "/")
 	conf.basePath = conf.Host + ":" + conf.Port
@@ -82,6 +154,7 @@ func New[T any](storage TokenStorage, getUserDetails GetUserDetailsFunc[T], opts
 		storage:               storage,
 		conf:                  conf,
 		getUserDetailsByEmail: getUserDetails,
+		stateStore:            NewOAuthStateStoreWithContextStorage(storage),
 	}
 
 	for _, o := range opts {
@@ -108,6 +181,29 @@ func WithProvider[T any](provider oauth.Provider) Option[T] {
 	}
 }
 
+// WithRefreshTokenReuseHandler will be notified each time reuse of already rotated refresh token is detected.
+func WithRefreshTokenReuseHandler[T any](onReuse RefreshTokenReuseFunc[T]) Option[T] {
+	return func(a *Auth[T]) {
+		a.onRefreshTokenReuse = onReuse
+	}
+}
+
+// WithUserLookup will find users of OAuth login with lookup instead of GetUserDetailsFunc.
+func WithUserLookup[T any](lookup UserLookupFunc[T]) Option[T] {
+	return func(a *Auth[T]) {
+		a.lookupUser = lookup
+	}
+}
+
+// WithUserProvisioning will create users with provision when UserLookupFunc returns ErrUserNotFound.
+// Only emails passing Config.AllowedEmailDomains and Config.DeniedEmailDomains are provisioned, and when either list
+// is configured only verified emails.
+func WithUserProvisioning[T any](provision UserProvisionFunc[T]) Option[T] {
+	return func(a *Auth[T]) {
+		a.provisionUser = provision
+	}
+}
+
 func WithConfig[T any](conf *Config) Option[T] {
 	return func(a *Auth[T]) {
 		a.conf = conf
@@ -130,17 +226,43 @@ func (auth *Auth[T]) GetClaimsFromRequest(r *http.Request) (TokenClaims, error)
 
 // createSession will create login session.
 // Generate access and refresh tokens and save both tokens in cache storage.
-func (auth *Auth[T]) createSession(claims TokenClaims) (TokenDetails, error) {
+// Session limit policy is applied against user's stored sessions.
+func (auth *Auth[T]) createSession(ctx context.Context, claims TokenClaims, session Session) (TokenDetails, error) {
 	if err := validateClaims(claims); err != nil {
 		return TokenDetails{}, err
 	}
 
-	td, err := auth.generateTokens(claims)
+	if err := auth.enforceSessionLimit(ctx, subjectKey(claims["sub"])); err != nil {
+		return TokenDetails{}, err
+	}
+
+	return auth.issueTokens(ctx, claims, session)
+}
+
+// issueTokens will generate access and refresh tokens within given session (refresh token family) and save them in cache storage.
+// Extra claims from ClaimsEnricherFunc are added to tokens.
+func (auth *Auth[T]) issueTokens(ctx context.Context, claims TokenClaims, session Session) (TokenDetails, error) {
+	if err := validateClaims(claims); err != nil {
+		return TokenDetails{}, err
+	}
+
+	accessTokenClaims, refreshTokenClaims, err := auth.tokenClaims(ctx, claims)
+	if err != nil {
+		return TokenDetails{}, err
+	}
+
+	td, err := auth.generateTokens(accessTokenClaims, refreshTokenClaims)
 	if err != nil {
 		return TokenDetails{}, err
 	}
 
-	if err = auth.storeTokensInCache(claims["sub"], td); err != nil {
+	session.ExpiresAt = time.Now().UTC().Add(td.refreshTokenExpiry)
+
+	if err = auth.storeTokensInCache(ctx, claims["sub"], session, td); err != nil {
+		return TokenDetails{}, err
+	}
+
+	if err = auth.addToSessionIndex(ctx, subjectKey(claims["sub"]), session.ID); err != nil {
 		return TokenDetails{}, err
 	}
 
@@ -148,13 +270,23 @@ func (auth *Auth[T]) createSession(claims TokenClaims) (TokenDetails, error) {
 }
 
 // generateTokens will generate a pair of access and refresh tokens.
-func (auth *Auth[T]) generateTokens(claims TokenClaims) (TokenDetails, error) {
-	accessTokenUuid, accessTokenValue, err := generateToken(auth.conf.AccessTokenSecret, auth.conf.AccessTokenExpiry, claims)
+func (auth *Auth[T]) generateTokens(accessTokenClaims, refreshTokenClaims TokenClaims) (TokenDetails, error) {
+	accessTokenKey, err := auth.accessTokenKey()
+	if err != nil {
+		return TokenDetails{}, err
+	}
+
+	refreshTokenKey, err := auth.refreshTokenKey()
+	if err != nil {
+		return TokenDetails{}, err
+	}
+
+	accessTokenUuid, accessTokenValue, err := auth.generateToken(accessTokenKey, auth.conf.AccessTokenExpiry, accessTokenClaims)
 	if err != nil {
 		return TokenDetails{}, err
 	}
 
-	refreshTokenUuid, refreshTokenValue, err := generateToken(auth.conf.RefreshTokenSecret, auth.conf.RefreshTokenExpiry, claims)
+	refreshTokenUuid, refreshTokenValue, err := auth.generateToken(refreshTokenKey, auth.conf.RefreshTokenExpiry, refreshTokenClaims)
 	if err != nil {
 		return TokenDetails{}, err
 	}
@@ -170,81 +302,169 @@ func (auth *Auth[T]) generateTokens(claims TokenClaims) (TokenDetails, error) {
 }
 
 // storeTokensInCache will save access and refresh tokens in cache.
-func (auth *Auth[T]) storeTokensInCache(sub interface{}, td TokenDetails) error {
+// Cached access token lives as long as refresh token, so expired access token can still be used to find its refresh token on logout.
+// Access token expiry is still validated from its exp claim.
+// Session (refresh token family) is updated to point to the latest pair of tokens.
+func (auth *Auth[T]) storeTokensInCache(ctx context.Context, sub interface{}, session Session, td TokenDetails) error {
 	// cross-reference properties are created, so we can later easily find connection between access and refresh tokens
 	// it's needed for easier cleanup on logout and refresh/token
 
 	accessTokenCacheValue := TokenClaims{
 		"sub":                sub,
 		"refresh_token_uuid": td.refreshTokenUuid,
+		"family_id":          session.ID,
 	}
 	refreshTokenCacheValue := TokenClaims{
 		"sub":               sub,
 		"access_token_uuid": td.accessTokenUuid,
+		"family_id":         session.ID,
+	}
+	sessionCacheValue := &cachedSession{
+		Session:          session,
+		Sub:              sub,
+		SubKey:           subjectKey(sub),
+		AccessTokenUuid:  td.accessTokenUuid,
+		RefreshTokenUuid: td.refreshTokenUuid,
 	}
 
-	return auth.storage.Store(
-		&Item{
+	items := []*Item{
+		{
 			Key:        td.accessTokenUuid,
 			Value:      accessTokenCacheValue,
-			Expiration: td.accessTokenExpiry,
-		}, &Item{
+			Expiration: td.refreshTokenExpiry,
+		}, {
 			Key:        td.refreshTokenUuid,
 			Value:      refreshTokenCacheValue,
 			Expiration: td.refreshTokenExpiry,
-		})
+		}, {
+			Key:        familyKey(session.ID),
+			Value:      sessionCacheValue,
+			Expiration: td.refreshTokenExpiry,
+		},
+	}
+	if auth.conf.IdleTimeout > 0 {
+		items = append(items, auth.idleItem(session.ID))
+	}
+
+	return auth.storage.StoreContext(ctx, items...)
 }
 
-// destroySession will remove access and refresh tokens from cache.
-func (auth *Auth[T]) destroySession(accessToken string) error {
+// destroySession will remove session with its access and refresh tokens from cache.
+// Expired access token is accepted as long as its session is still in cache.
+func (auth *Auth[T]) destroySession(ctx context.Context, accessToken string) error {
 	accessTokenClaims, err := auth.extractAccessTokenClaims(accessToken)
+	expired := errors.Is(err, ErrTokenExpired)
+	if expired {
+		accessTokenClaims, err = auth.extractExpiredAccessTokenClaims(accessToken)
+	}
 	if err != nil {
 		return err
 	}
 
-	accessTokenUuid := accessTokenClaims["uuid"]
-	if accessTokenUuid == nil {
+	accessTokenUuid, ok := tokenId(accessTokenClaims)
+	if !ok {
 		return errors.New("invalid claims from access_token")
 	}
 
-	accessTokenCached, err := auth.getTokenFromCache(accessTokenUuid.(string))
+	accessTokenCached, err := auth.getTokenFromCache(ctx, accessTokenUuid)
 	if err != nil {
+		if expired {
+			return ErrTokenExpired
+		}
 		return err
 	}
 
-	refreshTokenUuid, ok := accessTokenCached["refresh_token_uuid"]
+	familyId, ok := accessTokenCached["family_id"].(string)
 	if !ok {
-		return errors.New("refresh_token_uuid not found in cached access_token")
+		return errors.New("family_id not found in cached access_token")
+	}
+
+	session, err := auth.getSessionFromCache(ctx, familyId)
+	if err != nil {
+		return err
 	}
 
-	return auth.storage.Delete(accessTokenUuid.(string), refreshTokenUuid.(string))
+	return auth.revokeSession(ctx, session)
 }
 
-// extractAccessTokenClaims will validate and extract access token claims. Access token secret is used for validation.
+// extractAccessTokenClaims will validate and extract access token claims (exp, nbf, iat, iss, aud).
+// Access token secret, signing key or key from KeySet (by kid) is used for validation.
 func (auth *Auth[T]) extractAccessTokenClaims(accessToken string) (TokenClaims, error) {
-	return extractToken(accessToken, auth.conf.AccessTokenSecret)
+	return auth.parseAccessToken(accessToken, auth.accessTokenValidator())
 }
 
-// extractRefreshTokenClaims will validate and extract refresh token. Refresh token secret is used for validation.
-func (auth *Auth[T]) extractRefreshTokenClaims(refreshToken string) (TokenClaims, error) {
-	return extractToken(refreshToken, auth.conf.RefreshTokenSecret)
+// extractExpiredAccessTokenClaims will validate access token signature and extract its claims, claims are not validated.
+func (auth *Auth[T]) extractExpiredAccessTokenClaims(accessToken string) (TokenClaims, error) {
+	return auth.parseAccessToken(accessToken, nil)
 }
 
-// getTokenFromCache will get and unmarshal token from cache.
-func (auth *Auth[T]) getTokenFromCache(tokenUuid string) (TokenClaims, error) {
-	cachedTokenBytes, err := auth.storage.Load(tokenUuid)
+func (auth *Auth[T]) parseAccessToken(accessToken string, validator *claimsValidator) (TokenClaims, error) {
+	if auth.keySet != nil {
+		return parseToken(accessToken, auth.keySet.verificationKey, validator)
+	}
+
+	key, err := auth.accessTokenKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return key.parse(accessToken, validator)
+}
+
+// extractRefreshTokenClaims will validate and extract refresh token. Refresh token secret (or signing key) is used for validation.
+func (auth *Auth[T]) extractRefreshTokenClaims(refreshToken string) (TokenClaims, error) {
+	key, err := auth.refreshTokenKey()
 	if err != nil {
-		return nil, errors.New("token is no longer active")
+		return nil, err
+	}
+
+	return extractToken(refreshToken, key, auth.refreshTokenValidator())
+}
+
+// accessTokenKey will use active key of KeySet or AccessTokenSigningKey if configured, otherwise AccessTokenSecret.
+func (auth *Auth[T]) accessTokenKey() (*signingKey, error) {
+	if auth.keySet != nil {
+		return auth.keySet.signingKey(), nil
 	}
 
+	return newSigningKey(auth.conf.AccessTokenSecret, auth.conf.AccessTokenSigningKey)
+}
+
+// refreshTokenKey will use RefreshTokenSigningKey if configured, otherwise RefreshTokenSecret.
+func (auth *Auth[T]) refreshTokenKey() (*signingKey, error) {
+	return newSigningKey(auth.conf.RefreshTokenSecret, auth.conf.RefreshTokenSigningKey)
+}
+
+// getTokenFromCache will get and unmarshal token from cache.
+func (auth *Auth[T]) getTokenFromCache(ctx context.Context, tokenUuid string) (TokenClaims, error) {
 	var cachedToken TokenClaims
-	if err = json.Unmarshal(cachedTokenBytes, &cachedToken); err != nil {
-		return nil, errors.New("getTokenFromCache unmarshal failed: " + err.Error())
+	if err := auth.loadFromCache(ctx, tokenUuid, &cachedToken); err != nil {
+		return nil, err
 	}
 
 	return cachedToken, nil
 }
 
+// loadFromCache will get value from cache and unmarshal it into v, numbers are decoded as json.Number.
+// Transient storage errors (ErrStorageUnavailable) are returned as they are, any other error is reported as ErrTokenRevoked.
+func (auth *Auth[T]) loadFromCache(ctx context.Context, key string, v interface{}) error {
+	cachedBytes, err := auth.storage.LoadContext(ctx, key)
+	if errors.Is(err, ErrStorageUnavailable) {
+		return err
+	}
+	if err != nil {
+		return ErrTokenRevoked
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(cachedBytes))
+	decoder.UseNumber()
+	if err = decoder.Decode(v); err != nil {
+		return errors.New("loadFromCache unmarshal failed: " + err.Error())
+	}
+
+	return nil
+}
+
 // validateClaims will check for required TokenClaims.
 func validateClaims(claims TokenClaims) error {
 	_, ok := claims["sub"]
@@ -261,7 +481,7 @@ func validateClaims(claims TokenClaims) error {
 }
 
 // generateAuthClaims for access token.
-func generateAuthClaims(sub any, email string) TokenClaims {
+func generateAuthClaims[T any](sub T, email string) TokenClaims {
 	claims := make(TokenClaims)
 	claims["sub"] = sub
 	claims["email"] = email
@@ -270,22 +490,12 @@ func generateAuthClaims(sub any, email string) TokenClaims {
 }
 
 // generateToken is used for both access and refresh token.
-// It will generate token value and uuid.
+// It will generate token value and uuid (jti), registered claims are added to given claims.
 // Can be split into two separate functions if needed (ex. different claims used).
-func generateToken(tokenSecret []byte, tokenExpiry time.Duration, claims TokenClaims) (string, string, error) {
-	token := &jwt.Token{
-		Secret: tokenSecret,
-	}
-
-	tClaims := make(TokenClaims)
-	for k, v := range claims {
-		tClaims[k] = v
-	}
-	tUuid := uuid.New().String()
-	tClaims["exp"] = jwt.TokenExpiry(tokenExpiry)
-	tClaims["uuid"] = tUuid
+func (auth *Auth[T]) generateToken(key *signingKey, tokenExpiry time.Duration, claims TokenClaims) (string, string, error) {
+	tClaims, tUuid := auth.registeredClaims(claims, tokenExpiry)
 
-	tValue, err := token.Generate(tClaims)
+	tValue, err := key.sign(tClaims)
 	if err != nil {
 		return "", "", err
 	}
@@ -294,12 +504,8 @@ func generateToken(tokenSecret []byte, tokenExpiry time.Duration, claims TokenCl
 }
 
 // extractToken will validate and extract claims from given token
-func extractToken(token string, secret []byte) (TokenClaims, error) {
-	jwtToken := &jwt.Token{
-		Secret: secret,
-	}
-
-	return jwtToken.Validate(token)
+func extractToken(token string, key *signingKey, validator *claimsValidator) (TokenClaims, error) {
+	return key.parse(token, validator)
 }
 
 // getAccessTokenFromRequest will extract access token from request's Authorization headers.
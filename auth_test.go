@@ -0,0 +1,169 @@
+package hamr_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"golang.org/x/oauth2"
+
+	"github.com/semirm-dev/hamr"
+	"github.com/semirm-dev/hamr/oauth"
+	"github.com/semirm-dev/hamr/storage/memory"
+)
+
+// testProvider is oauth.Provider backed by test token server, every login returns its userInfo.
+type testProvider struct {
+	name     string
+	server   *httptest.Server
+	userInfo oauth.UserInfo
+}
+
+func newTestProvider(t *testing.T, name string, userInfo oauth.UserInfo) *testProvider {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "provider-token", "token_type": "bearer"})
+	}))
+	t.Cleanup(server.Close)
+
+	return &testProvider{
+		name:     name,
+		server:   server,
+		userInfo: userInfo,
+	}
+}
+
+func (p *testProvider) ClientId() string     { return "client-id" }
+func (p *testProvider) ClientSecret() string { return "client-secret" }
+func (p *testProvider) Name() string         { return p.name }
+func (p *testProvider) Scopes() []string     { return nil }
+
+func (p *testProvider) Endpoint() oauth2.Endpoint {
+	return oauth2.Endpoint{
+		AuthURL:  p.server.URL + "/auth",
+		TokenURL: p.server.URL + "/token",
+	}
+}
+
+func (p *testProvider) GetUserInfo(string) (*oauth.UserInfo, error) {
+	userInfo := p.userInfo
+	return &userInfo, nil
+}
+
+// newAuth will set up Auth[T] with in-memory storage and google test provider, logging in user id with verified email.
+func newAuth[T any](t *testing.T, id T, opts ...hamr.Option[T]) (*hamr.Auth[T], *hamr.Config) {
+	storage := memory.New()
+	t.Cleanup(storage.Close)
+
+	conf := hamr.NewConfig()
+
+	return newAuthWithStorage(t, storage, conf, id, opts...), conf
+}
+
+// newAuthWithStorage is newAuth with given storage and config, instances sharing them act as one deployment.
+func newAuthWithStorage[T any](t *testing.T, storage hamr.TokenStorage, conf *hamr.Config, id T, opts ...hamr.Option[T]) *hamr.Auth[T] {
+	google := newTestProvider(t, "google", oauth.UserInfo{
+		ExternalId:    "google-1",
+		Email:         "user@example.com",
+		EmailVerified: true,
+	})
+
+	getUserDetails := func(email string) hamr.UserDetails[T] {
+		return hamr.UserDetails[T]{ID: id}
+	}
+
+	opts = append([]hamr.Option[T]{
+		hamr.WithConfig[T](conf),
+		hamr.WithProvider[T](google),
+	}, opts...)
+
+	return hamr.New[T](storage, getUserDetails, opts...)
+}
+
+// login will go through OAuth login with provider p.
+func login[T any](t *testing.T, auth *hamr.Auth[T], p string) (hamr.TokenDetails, error) {
+	r := callbackRequest(t, "/", func(w http.ResponseWriter, r *http.Request) error {
+		return auth.OAauthLoginHandler(p, w, r)
+	})
+
+	return auth.OAuthLoginCallbackHandler(context.Background(), p, r)
+}
+
+// mustLogin is login which fails the test on error.
+func mustLogin[T any](t *testing.T, auth *hamr.Auth[T], p string) hamr.TokenDetails {
+	t.Helper()
+
+	td, err := login(t, auth, p)
+	if err != nil {
+		t.Fatalf("login() error = %v", err)
+	}
+
+	return td
+}
+
+// callbackRequest will start OAuth flow with start on request to target and return provider's redirect back to callback,
+// carrying state and anti-forgery cookie.
+func callbackRequest(t *testing.T, target string, start func(w http.ResponseWriter, r *http.Request) error) *http.Request {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	if err := start(w, httptest.NewRequest(http.MethodGet, target, nil)); err != nil {
+		t.Fatalf("failed to start oauth flow: %v", err)
+	}
+
+	loginUrl, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state := loginUrl.Query().Get("state")
+	r := httptest.NewRequest(http.MethodGet, "/callback?code=code&state="+url.QueryEscape(state), nil)
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+
+	return r
+}
+
+// authorizedRequest is request with given access token in Authorization header.
+func authorizedRequest(accessToken string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/protected", nil)
+	r.Header.Set("Authorization", "Bearer "+accessToken)
+
+	return r
+}
+
+// contextStorage implements only hamr.ContextTokenStorage, without Store, Load and Delete.
+type contextStorage struct {
+	hamr.ContextTokenStorage
+}
+
+func TestNewWithContextStorage(t *testing.T) {
+	storage := memory.New()
+	t.Cleanup(storage.Close)
+	ctxStorage := &contextStorage{hamr.StorageWithContext(storage)}
+
+	google := newTestProvider(t, "google", oauth.UserInfo{
+		ExternalId:    "google-1",
+		Email:         "user@example.com",
+		EmailVerified: true,
+	})
+
+	auth := hamr.NewWithContextStorage[uint](ctxStorage, func(email string) hamr.UserDetails[uint] {
+		return hamr.UserDetails[uint]{ID: 1}
+	}, hamr.WithProvider[uint](google), hamr.WithIdentityStore[uint](hamr.NewIdentityStoreWithContextStorage[uint](ctxStorage)))
+
+	td := mustLogin(t, auth, "google")
+
+	if err := auth.Authorized(authorizedRequest(td.AccessToken)); err != nil {
+		t.Fatalf("Authorized() error = %v", err)
+	}
+
+	identities, err := auth.ListIdentities(context.Background(), 1)
+	if err != nil || len(identities) != 1 {
+		t.Fatalf("ListIdentities() = %+v, %v, want google account linked on login", identities, err)
+	}
+}
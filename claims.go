@@ -0,0 +1,361 @@
+package hamr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Claims are typed access token claims, Subject is decoded into user id type T.
+type Claims[T any] struct {
+	Subject   T
+	Email     string
+	TokenId   string
+	Issuer    string
+	Audience  []string
+	IssuedAt  time.Time
+	NotBefore time.Time
+	ExpiresAt time.Time
+	// Custom holds application claims registered with WithCustomClaims, nil otherwise.
+	Custom CustomClaims
+}
+
+// CustomClaims is application's own claims struct. It is decoded from access token claims (by json tags)
+// and validated on each authorized request.
+type CustomClaims interface {
+	Validate() error
+}
+
+// NewCustomClaimsFunc returns new (empty) application claims struct, ex. &MyClaims{}.
+type NewCustomClaimsFunc func() CustomClaims
+
+// WithCustomClaims will register application claims struct, decoded and validated on each authorized request.
+func WithCustomClaims[T any](newClaims NewCustomClaimsFunc) Option[T] {
+	return func(a *Auth[T]) {
+		a.newCustomClaims = newClaims
+	}
+}
+
+// GetTypedClaimsFromRequest will validate access token from request and decode its claims, including custom claims.
+// Token is not checked against cache storage, use Authorized middleware for that.
+func (auth *Auth[T]) GetTypedClaimsFromRequest(r *http.Request) (*Claims[T], error) {
+	claims, err := auth.GetClaimsFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	typedClaims, err := decodeClaims[T](claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = auth.decodeCustomClaims(claims, typedClaims); err != nil {
+		return nil, err
+	}
+
+	return typedClaims, nil
+}
+
+// decodeClaims will decode token claims into typed claims. Subject is decoded into T.
+func decodeClaims[T any](claims TokenClaims) (*Claims[T], error) {
+	typedClaims := &Claims[T]{}
+
+	sub, ok := claims["sub"]
+	if !ok {
+		return nil, errors.New("missing sub from claims")
+	}
+	if err := decodeClaim(sub, &typedClaims.Subject); err != nil {
+		return nil, errors.New("invalid sub claim: " + err.Error())
+	}
+
+	typedClaims.Email, _ = claims["email"].(string)
+	typedClaims.TokenId, _ = tokenId(claims)
+	typedClaims.Issuer, _ = claims["iss"].(string)
+	typedClaims.Audience = audiences(claims["aud"])
+
+	var err error
+	if typedClaims.IssuedAt, _, err = timeClaim(claims, "iat"); err != nil {
+		return nil, err
+	}
+	if typedClaims.NotBefore, _, err = timeClaim(claims, "nbf"); err != nil {
+		return nil, err
+	}
+	if typedClaims.ExpiresAt, _, err = timeClaim(claims, "exp"); err != nil {
+		return nil, err
+	}
+
+	return typedClaims, nil
+}
+
+// decodeCustomClaims will decode and validate application claims registered with WithCustomClaims.
+func (auth *Auth[T]) decodeCustomClaims(claims TokenClaims, typedClaims *Claims[T]) error {
+	if auth.newCustomClaims == nil {
+		return nil
+	}
+
+	custom := auth.newCustomClaims()
+	if err := decodeClaim(claims, custom); err != nil {
+		return errors.New("invalid custom claims: " + err.Error())
+	}
+
+	if err := custom.Validate(); err != nil {
+		return err
+	}
+	typedClaims.Custom = custom
+
+	return nil
+}
+
+// decodeClaim will decode claim parsed from json (string, float64, map...) into typed value.
+func decodeClaim(claim interface{}, v interface{}) error {
+	b, err := json.Marshal(claim)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+// reservedClaims are set by hamr and can not be overwritten by ClaimsEnricherFunc.
+var reservedClaims = []string{"sub", "email", "iss", "aud", "exp", "nbf", "iat", "jti", "uuid"}
+
+// ExtraClaims are application claims added to tokens, ex. roles, tenant id or display name.
+// Access and refresh tokens may carry different claims.
+type ExtraClaims struct {
+	Access  TokenClaims
+	Refresh TokenClaims
+}
+
+// ClaimsEnricherFunc returns extra claims of a user. It is called on login and on each refresh, so claims stay up to date.
+type ClaimsEnricherFunc[T any] func(ctx context.Context, sub T) (ExtraClaims, error)
+
+// WithClaimsEnricher will add claims returned by enrich to access and refresh tokens. Reserved claims can not be overwritten.
+func WithClaimsEnricher[T any](enrich ClaimsEnricherFunc[T]) Option[T] {
+	return func(a *Auth[T]) {
+		a.enrichClaims = enrich
+	}
+}
+
+// tokenClaims will build access and refresh token claims from auth claims (sub + email) and extra claims of a user.
+func (auth *Auth[T]) tokenClaims(ctx context.Context, claims TokenClaims) (TokenClaims, TokenClaims, error) {
+	if auth.enrichClaims == nil {
+		return claims, claims, nil
+	}
+
+	var sub T
+	if err := decodeClaim(claims["sub"], &sub); err != nil {
+		return nil, nil, errors.New("invalid sub claim: " + err.Error())
+	}
+
+	extra, err := auth.enrichClaims(ctx, sub)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	accessClaims, err := mergeClaims(claims, extra.Access)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refreshClaims, err := mergeClaims(claims, extra.Refresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return accessClaims, refreshClaims, nil
+}
+
+// mergeClaims will copy claims and add extra claims to them. Extra claims must not contain reserved claims.
+func mergeClaims(claims, extra TokenClaims) (TokenClaims, error) {
+	merged := make(TokenClaims, len(claims)+len(extra))
+	for k, v := range claims {
+		merged[k] = v
+	}
+
+	for k, v := range extra {
+		for _, reserved := range reservedClaims {
+			if k == reserved {
+				return nil, fmt.Errorf("claim %s is reserved", k)
+			}
+		}
+		merged[k] = v
+	}
+
+	return merged, nil
+}
+
+// claimsValidator validates registered claims of parsed token. Time based claims (exp, nbf, iat) are validated with leeway,
+// issuer and audience only when configured.
+type claimsValidator struct {
+	issuer   string
+	audience []string
+	leeway   time.Duration
+}
+
+// registeredClaims will add registered claims (iss, aud, iat, nbf, exp, jti) to token claims.
+// Token id is written both as jti and as uuid, tokens issued by previous versions only have uuid.
+func (auth *Auth[T]) registeredClaims(claims TokenClaims, tokenExpiry time.Duration) (TokenClaims, string) {
+	tClaims := make(TokenClaims)
+	for k, v := range claims {
+		tClaims[k] = v
+	}
+
+	if auth.conf.Issuer != "" {
+		tClaims["iss"] = auth.conf.Issuer
+	}
+
+	switch len(auth.conf.Audience) {
+	case 0:
+	case 1:
+		tClaims["aud"] = auth.conf.Audience[0]
+	default:
+		tClaims["aud"] = auth.conf.Audience
+	}
+
+	now := time.Now().UTC()
+	tUuid := uuid.New().String()
+	tClaims["iat"] = now.Unix()
+	tClaims["nbf"] = now.Unix()
+	tClaims["exp"] = now.Add(tokenExpiry).Unix()
+	tClaims["jti"] = tUuid
+	tClaims["uuid"] = tUuid
+
+	return tClaims, tUuid
+}
+
+// accessTokenValidator validates expiry, issuer and audience of access tokens.
+func (auth *Auth[T]) accessTokenValidator() *claimsValidator {
+	return &claimsValidator{
+		issuer:   auth.conf.Issuer,
+		audience: auth.conf.Audience,
+		leeway:   auth.conf.Leeway,
+	}
+}
+
+// refreshTokenValidator validates only expiry of refresh tokens, they are never sent to other services
+// and long-lived refresh tokens issued before issuer was configured keep working.
+func (auth *Auth[T]) refreshTokenValidator() *claimsValidator {
+	return &claimsValidator{
+		leeway: auth.conf.Leeway,
+	}
+}
+
+func (v *claimsValidator) validate(claims TokenClaims) error {
+	now := time.Now()
+
+	exp, ok, err := timeClaim(claims, "exp")
+	if err != nil {
+		return err
+	}
+	if ok && now.After(exp.Add(v.leeway)) {
+		return ErrTokenExpired
+	}
+
+	nbf, ok, err := timeClaim(claims, "nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Before(nbf.Add(-v.leeway)) {
+		return errors.New("token is not valid yet")
+	}
+
+	iat, ok, err := timeClaim(claims, "iat")
+	if err != nil {
+		return err
+	}
+	if ok && now.Before(iat.Add(-v.leeway)) {
+		return errors.New("token used before issued")
+	}
+
+	if v.issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.issuer {
+			return errors.New("invalid token issuer")
+		}
+	}
+
+	if len(v.audience) > 0 && !containsAudience(claims["aud"], v.audience) {
+		return errors.New("invalid token audience")
+	}
+
+	return nil
+}
+
+// tokenId will get token id from jti claim, or from uuid claim of tokens issued by previous versions.
+func tokenId(claims TokenClaims) (string, bool) {
+	if jti, ok := claims["jti"].(string); ok && jti != "" {
+		return jti, true
+	}
+
+	tUuid, ok := claims["uuid"].(string)
+
+	return tUuid, ok && tUuid != ""
+}
+
+// timeClaim will get NumericDate claim, seconds since epoch. Tokens are parsed with json.Number, float64 is accepted too.
+func timeClaim(claims TokenClaims, name string) (time.Time, bool, error) {
+	v, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+
+	var seconds float64
+	switch n := v.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		if err != nil {
+			return time.Time{}, false, errors.New("invalid " + name + " claim")
+		}
+		seconds = f
+	case float64:
+		seconds = n
+	default:
+		return time.Time{}, false, errors.New("invalid " + name + " claim")
+	}
+
+	return time.Unix(int64(seconds), 0), true, nil
+}
+
+// sameSubject reports whether sub read from cache (json) is the same user as typed sub. Cached sub is decoded into T first.
+func sameSubject[T any](sub T, cachedSub interface{}) bool {
+	var decoded T
+	if err := decodeClaim(cachedSub, &decoded); err != nil {
+		return false
+	}
+
+	return subjectKey(decoded) == subjectKey(sub)
+}
+
+// containsAudience reports whether aud claim (string or array) contains any of accepted audiences.
+func containsAudience(aud interface{}, accepted []string) bool {
+	for _, a := range audiences(aud) {
+		for _, acc := range accepted {
+			if a == acc {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// audiences of aud claim, which is either a string or an array of strings.
+func audiences(aud interface{}) []string {
+	var result []string
+	switch a := aud.(type) {
+	case string:
+		result = []string{a}
+	case []interface{}:
+		for _, v := range a {
+			if s, ok := v.(string); ok {
+				result = append(result, s)
+			}
+		}
+	}
+
+	return result
+}
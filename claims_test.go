@@ -0,0 +1,248 @@
+package hamr_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	jwtLib "github.com/golang-jwt/jwt/v4"
+
+	"github.com/semirm-dev/hamr"
+)
+
+func TestAuth_RegisteredClaims(t *testing.T) {
+	auth, conf := newAuth[uint](t, 1)
+	conf.Issuer = "https://auth.example.com"
+	conf.Audience = []string{"api", "web"}
+	td := mustLogin(t, auth, "google")
+
+	claims, err := auth.AuthorizedClaims(authorizedRequest(td.AccessToken))
+	if err != nil {
+		t.Fatalf("AuthorizedClaims() error = %v", err)
+	}
+
+	if claims.Issuer != "https://auth.example.com" {
+		t.Errorf("iss = %s, want https://auth.example.com", claims.Issuer)
+	}
+	if len(claims.Audience) != 2 || claims.Audience[0] != "api" || claims.Audience[1] != "web" {
+		t.Errorf("aud = %v, want [api web]", claims.Audience)
+	}
+	if claims.TokenId == "" {
+		t.Error("jti is empty")
+	}
+	if claims.IssuedAt.IsZero() || claims.NotBefore.IsZero() || !claims.ExpiresAt.After(claims.IssuedAt) {
+		t.Errorf("iat = %v, nbf = %v, exp = %v", claims.IssuedAt, claims.NotBefore, claims.ExpiresAt)
+	}
+
+	rawClaims, err := auth.GetClaimsFromRequest(authorizedRequest(td.AccessToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rawClaims["jti"] != rawClaims["uuid"] {
+		t.Errorf("jti = %v, uuid = %v, want the same token id", rawClaims["jti"], rawClaims["uuid"])
+	}
+}
+
+func TestAuth_RegisteredClaims_Validation(t *testing.T) {
+	tests := map[string]struct {
+		issuer   string
+		audience []string
+	}{
+		"other issuer":   {issuer: "https://other.example.com", audience: []string{"api"}},
+		"other audience": {issuer: "https://auth.example.com", audience: []string{"billing"}},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			auth, conf := newAuth[uint](t, 1)
+			conf.Issuer = "https://auth.example.com"
+			conf.Audience = []string{"api"}
+			td := mustLogin(t, auth, "google")
+
+			conf.Issuer = tt.issuer
+			conf.Audience = tt.audience
+
+			if err := auth.Authorized(authorizedRequest(td.AccessToken)); err == nil {
+				t.Fatal("Authorized() error = nil, want error")
+			}
+
+			// refresh tokens are validated only for expiry
+			if _, err := auth.RefreshTokenHandler(context.Background(), td.RefreshToken); err != nil {
+				t.Fatalf("RefreshTokenHandler() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestAuth_RegisteredClaims_Leeway(t *testing.T) {
+	auth, conf := newAuth[uint](t, 1)
+	conf.AccessTokenExpiry = -time.Second * 5
+	td := mustLogin(t, auth, "google")
+
+	if err := auth.Authorized(authorizedRequest(td.AccessToken)); err == nil {
+		t.Fatal("Authorized() with expired access token error = nil, want error")
+	}
+
+	conf.Leeway = time.Minute
+
+	if err := auth.Authorized(authorizedRequest(td.AccessToken)); err != nil {
+		t.Fatalf("Authorized() within leeway error = %v", err)
+	}
+}
+
+type profileClaims struct {
+	Email string `json:"email"`
+}
+
+func (c *profileClaims) Validate() error {
+	if !strings.HasSuffix(c.Email, "@example.com") {
+		return errors.New("email must be from example.com")
+	}
+
+	return nil
+}
+
+type roleClaims struct {
+	Role string `json:"role"`
+}
+
+func (c *roleClaims) Validate() error {
+	if c.Role == "" {
+		return errors.New("missing role")
+	}
+
+	return nil
+}
+
+func TestAuth_TypedClaims(t *testing.T) {
+	auth, _ := newAuth[string](t, "user-abc", hamr.WithCustomClaims[string](func() hamr.CustomClaims {
+		return &profileClaims{}
+	}))
+	td := mustLogin(t, auth, "google")
+
+	claims, err := auth.AuthorizedClaims(authorizedRequest(td.AccessToken))
+	if err != nil {
+		t.Fatalf("AuthorizedClaims() error = %v", err)
+	}
+
+	if claims.Subject != "user-abc" {
+		t.Errorf("Subject = %s, want user-abc", claims.Subject)
+	}
+	if claims.Email != "user@example.com" {
+		t.Errorf("Email = %s, want user@example.com", claims.Email)
+	}
+
+	custom, ok := claims.Custom.(*profileClaims)
+	if !ok || custom.Email != "user@example.com" {
+		t.Errorf("Custom = %#v, want *profileClaims with email", claims.Custom)
+	}
+}
+
+func TestAuth_TypedClaims_InvalidCustomClaims(t *testing.T) {
+	auth, _ := newAuth[uint](t, 1, hamr.WithCustomClaims[uint](func() hamr.CustomClaims {
+		return &roleClaims{}
+	}))
+	td := mustLogin(t, auth, "google")
+
+	if err := auth.Authorized(authorizedRequest(td.AccessToken)); err == nil {
+		t.Fatal("Authorized() with invalid custom claims error = nil, want error")
+	}
+}
+
+func TestAuth_TypedClaims_NumericSubject(t *testing.T) {
+	for _, sub := range subjects {
+		t.Run(fmt.Sprint(sub), func(t *testing.T) {
+			auth, _ := newAuth(t, sub)
+			td := mustLogin(t, auth, "google")
+
+			claims, err := auth.AuthorizedClaims(authorizedRequest(td.AccessToken))
+			if err != nil {
+				t.Fatalf("AuthorizedClaims() error = %v", err)
+			}
+			if claims.Subject != sub {
+				t.Errorf("Subject = %d, want %d", claims.Subject, sub)
+			}
+
+			refreshed, err := auth.RefreshTokenHandler(context.Background(), td.RefreshToken)
+			if err != nil {
+				t.Fatalf("RefreshTokenHandler() error = %v", err)
+			}
+
+			if err = auth.Authorized(authorizedRequest(refreshed.AccessToken)); err != nil {
+				t.Fatalf("Authorized() with refreshed access token error = %v", err)
+			}
+		})
+	}
+}
+
+func TestAuth_ClaimsEnricher(t *testing.T) {
+	role := "viewer"
+	auth, _ := newAuth[uint](t, 1, hamr.WithClaimsEnricher[uint](func(ctx context.Context, sub uint) (hamr.ExtraClaims, error) {
+		return hamr.ExtraClaims{
+			Access:  hamr.TokenClaims{"role": role},
+			Refresh: hamr.TokenClaims{"tenant": "t1"},
+		}, nil
+	}))
+	td := mustLogin(t, auth, "google")
+
+	accessClaims := unverifiedClaims(t, td.AccessToken)
+	if accessClaims["role"] != "viewer" || accessClaims["tenant"] != nil {
+		t.Errorf("access token claims = %v, want role and no tenant", accessClaims)
+	}
+
+	refreshClaims := unverifiedClaims(t, td.RefreshToken)
+	if refreshClaims["tenant"] != "t1" || refreshClaims["role"] != nil {
+		t.Errorf("refresh token claims = %v, want tenant and no role", refreshClaims)
+	}
+
+	// claims are enriched again on refresh, so they stay up to date
+	role = "admin"
+	refreshed, err := auth.RefreshTokenHandler(context.Background(), td.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := unverifiedClaims(t, refreshed.AccessToken)["role"]; got != "admin" {
+		t.Errorf("refreshed access token role = %v, want admin", got)
+	}
+}
+
+func TestAuth_ClaimsEnricher_ReservedClaims(t *testing.T) {
+	for _, claim := range []string{"sub", "email", "iss", "aud", "exp", "nbf", "iat", "jti", "uuid"} {
+		t.Run(claim, func(t *testing.T) {
+			auth, _ := newAuth[uint](t, 1, hamr.WithClaimsEnricher[uint](func(ctx context.Context, sub uint) (hamr.ExtraClaims, error) {
+				return hamr.ExtraClaims{Access: hamr.TokenClaims{claim: "overwritten"}}, nil
+			}))
+
+			if _, err := login(t, auth, "google"); err == nil {
+				t.Fatal("login() error = nil, want reserved claim error")
+			}
+		})
+	}
+}
+
+func TestAuth_ClaimsEnricher_Error(t *testing.T) {
+	enrichErr := errors.New("claims backend down")
+	auth, _ := newAuth[uint](t, 1, hamr.WithClaimsEnricher[uint](func(ctx context.Context, sub uint) (hamr.ExtraClaims, error) {
+		return hamr.ExtraClaims{}, enrichErr
+	}))
+
+	if _, err := login(t, auth, "google"); !errors.Is(err, enrichErr) {
+		t.Fatalf("login() error = %v, want enricher error", err)
+	}
+}
+
+// unverifiedClaims will read token claims, without verifying it.
+func unverifiedClaims(t *testing.T, token string) jwtLib.MapClaims {
+	t.Helper()
+
+	claims := jwtLib.MapClaims{}
+	if _, _, err := jwtLib.NewParser().ParseUnverified(token, claims); err != nil {
+		t.Fatal(err)
+	}
+
+	return claims
+}
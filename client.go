@@ -0,0 +1,61 @@
+package hamr
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// clientIP will get client IP from request.
+// X-Forwarded-For is used only when request comes from trusted proxy, the first untrusted address from the right is client IP.
+func (auth *Auth[T]) clientIP(r *http.Request) string {
+	remoteIP := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		remoteIP = host
+	}
+
+	if !auth.isTrustedProxy(remoteIP) {
+		return remoteIP
+	}
+
+	forwardedFor := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwardedFor) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwardedFor[i])
+		if ip == "" {
+			continue
+		}
+
+		if !auth.isTrustedProxy(ip) {
+			return ip
+		}
+	}
+
+	return remoteIP
+}
+
+// isTrustedProxy will check if ip matches any of the trusted proxies (IP or CIDR).
+func (auth *Auth[T]) isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, proxy := range auth.conf.TrustedProxies {
+		if strings.Contains(proxy, "/") {
+			prefix, err := netip.ParsePrefix(proxy)
+			if err == nil && prefix.Contains(addr) {
+				return true
+			}
+			continue
+		}
+
+		proxyAddr, err := netip.ParseAddr(proxy)
+		if err == nil && proxyAddr.Unmap() == addr {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,50 @@
+package hamr
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotFound should be returned (or wrapped) by TokenStorage when key does not exist or has expired.
+	ErrNotFound = errors.New("key does not exist")
+	// ErrStorageUnavailable is matched by TokenStorage errors marked with Transient, auth backend is temporarily unavailable.
+	ErrStorageUnavailable = errors.New("token storage unavailable")
+	// ErrTokenExpired is returned when token is no longer valid because it has expired.
+	ErrTokenExpired = errors.New("token has expired")
+	// ErrTokenRevoked is returned when token is not found in cache storage, it was revoked (logout, refresh) or never existed.
+	ErrTokenRevoked = errors.New("token is no longer active")
+	// ErrRefreshTokenReused is returned when already rotated refresh token is used again. Whole refresh token family is revoked.
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+	// ErrSessionNotFound is returned when session does not exist or does not belong to given user.
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrSessionExpired is returned when session passed its idle timeout or absolute lifetime. Session is revoked.
+	ErrSessionExpired = errors.New("session has expired")
+	// ErrUserNotFound should be returned (or wrapped) by UserLookupFunc when user of OAuth login does not exist. Login is rejected.
+	ErrUserNotFound = errors.New("user not found")
+	// ErrEmailDomainNotAllowed is returned when user can not be provisioned because of email domain allow or deny list.
+	ErrEmailDomainNotAllowed = errors.New("email domain not allowed")
+	// ErrIdentityNotFound is returned when provider account is not linked to any user.
+	ErrIdentityNotFound = errors.New("identity not found")
+	// ErrIdentityAlreadyLinked is returned when provider account is already linked to another user.
+	ErrIdentityAlreadyLinked = errors.New("identity already linked to another user")
+)
+
+// Transient will mark TokenStorage error as transient backend failure (connection lost, timeout...).
+// Marked error matches both ErrStorageUnavailable and the original error.
+func Transient(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %w", ErrStorageUnavailable, err)
+}
+
+// SessionLimitError is returned on login when user already has maximum number of concurrent sessions.
+type SessionLimitError struct {
+	Limit int
+}
+
+func (e *SessionLimitError) Error() string {
+	return fmt.Sprintf("maximum number of concurrent sessions (%d) reached", e.Limit)
+}
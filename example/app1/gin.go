@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"net/http"
 
 	gormadapter "github.com/casbin/gorm-adapter/v3"
@@ -28,21 +29,62 @@ func MapAuthRoutesGin[T any](auth *hamr.Auth[T], router *gin.Engine) {
 	r.GET(":provider/callback", func(c *gin.Context) {
 		provider := c.Param("provider")
 
-		tokens, err := auth.OAuthLoginCallbackHandler(c.Request.Context(), provider, c.Request)
+		result, err := auth.OAuthCallbackHandler(c.Request.Context(), provider, c.Request)
 		if err != nil {
 			logrus.Error(err)
 			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		if result.Linked {
+			c.Status(http.StatusNoContent)
+			return
+		}
+
+		c.JSON(http.StatusOK, result.Tokens)
+	})
+
+	r.POST("token/refresh", func(c *gin.Context) {
+		var req struct {
+			RefreshToken string
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			logrus.Error(err)
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		tokens, err := auth.RefreshTokenHandler(c.Request.Context(), req.RefreshToken)
+		if err != nil {
+			logrus.Error(err)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
 		}
 
 		c.JSON(http.StatusOK, tokens)
 	})
+
+	router.GET(".well-known/jwks.json", func(c *gin.Context) {
+		auth.JWKSHandler(c.Writer, c.Request)
+	})
+
+	r.POST("logout", func(c *gin.Context) {
+		if err := auth.LogoutHandler(c.Request); err != nil {
+			logrus.Error(err)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	})
 }
 
 func Authorized[T any](auth *hamr.Auth[T]) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if err := auth.Authorized(c.Request); err != nil {
 			logrus.Error(err)
-			c.AbortWithStatus(http.StatusUnauthorized)
+			c.AbortWithStatus(authErrorStatus(err))
+			return
 		}
 
 		c.Next()
@@ -69,13 +111,23 @@ func AuthorizedCasbin[T any](auth *hamr.Auth[T], obj, act string) gin.HandlerFun
 	return func(c *gin.Context) {
 		if err = auth.AuthorizedWithCasbin(obj, act, policy, adapter, c.Request); err != nil {
 			logrus.Error(err)
-			c.AbortWithStatus(http.StatusUnauthorized)
+			c.AbortWithStatus(authErrorStatus(err))
+			return
 		}
 
 		c.Next()
 	}
 }
 
+// authErrorStatus tells unauthorized requests apart from unavailable auth backend.
+func authErrorStatus(err error) int {
+	if errors.Is(err, hamr.ErrStorageUnavailable) {
+		return http.StatusServiceUnavailable
+	}
+
+	return http.StatusUnauthorized
+}
+
 func casbinPolicyModel() string {
 	return `
 		[request_definition]
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"net/http"
 
@@ -10,31 +11,41 @@ import (
 	"github.com/semirm-dev/hamr"
 	"github.com/semirm-dev/hamr/internal/env"
 	"github.com/semirm-dev/hamr/internal/web"
+	"github.com/semirm-dev/hamr/oauth"
 	"github.com/semirm-dev/hamr/oauth/providers"
+	"github.com/semirm-dev/hamr/storage/redis"
 )
 
 func main() {
 	flag.Parse()
 
-	tokenStorage := newRedisCacheStorage(
-		"",
-		"6379",
-		"",
-		0,
-	)
-	getUserDetails := func(email string) hamr.UserDetails[uint] {
+	redisConf := redis.NewConfig()
+	redisConf.KeyPrefix = "app1:"
+
+	tokenStorage, err := redis.New(context.Background(), redisConf)
+	if err != nil {
+		logrus.Fatal("failed to initialize redis connection: ", err)
+	}
+	defer func() {
+		if err = tokenStorage.Close(); err != nil {
+			logrus.Error(err)
+		}
+	}()
+
+	lookupUser := func(ctx context.Context, userInfo *oauth.UserInfo) (hamr.UserDetails[uint], error) {
+		//TODO: get user from database, return hamr.ErrUserNotFound if it does not exist
 		return hamr.UserDetails[uint]{
-			//TODO: get user from database
 			ID: 1,
-		}
+		}, nil
 	}
 	opts := []hamr.Option[uint]{
+		hamr.WithUserLookup[uint](lookupUser),
 		hamr.WithProvider[uint](providers.NewGoogle(
 			env.Get("GOOGLE_CLIENT_ID", ""),
 			env.Get("GOOGLE_CLIENT_SECRET", ""))),
 	}
 
-	auth := hamr.New(tokenStorage, getUserDetails, opts...)
+	auth := hamr.New[uint](tokenStorage, nil, opts...)
 
 	router := web.NewGinRouter()
 	MapAuthRoutesGin(auth, router)
@@ -42,10 +53,11 @@ func main() {
 	//example #1: protected without roles/policy
 	{
 		router.GET("protected", Authorized(auth), func(ctx *gin.Context) {
-			claims, err := auth.GetClaimsFromRequest(ctx.Request)
+			claims, err := auth.GetTypedClaimsFromRequest(ctx.Request)
 			if err != nil {
 				logrus.Error(err)
 				ctx.AbortWithStatus(http.StatusUnauthorized)
+				return
 			}
 
 			ctx.JSON(http.StatusOK, claims)
@@ -0,0 +1,225 @@
+package hamr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/semirm-dev/hamr/oauth"
+)
+
+const (
+	identityKeyPrefix   = "identity:"
+	identitiesKeyPrefix = "identities:"
+)
+
+// Identity links user to an account of OAuth provider, identified by provider and its external id.
+type Identity[T any] struct {
+	Sub        T         `json:"sub"`
+	Provider   string    `json:"provider"`
+	ExternalId string    `json:"external_id"`
+	Email      string    `json:"email"`
+	LinkedAt   time.Time `json:"linked_at"`
+}
+
+// IdentityStore keeps linked identities.
+// FindIdentity should return ErrIdentityNotFound when provider account is not linked to any user.
+type IdentityStore[T any] interface {
+	FindIdentity(ctx context.Context, provider, externalId string) (Identity[T], error)
+	ListIdentities(ctx context.Context, sub T) ([]Identity[T], error)
+	LinkIdentity(ctx context.Context, identity Identity[T]) error
+	UnlinkIdentity(ctx context.Context, sub T, provider string) error
+}
+
+// WithIdentityStore will look users up by linked identity (provider, external id) first on OAuth login.
+// Users found by verified email or provisioned on first login are linked automatically.
+func WithIdentityStore[T any](identities IdentityStore[T]) Option[T] {
+	return func(a *Auth[T]) {
+		a.identities = identities
+	}
+}
+
+// LinkIdentity will link provider account to a logged-in user.
+// ErrIdentityAlreadyLinked is returned if provider account is already linked to another user.
+func (auth *Auth[T]) LinkIdentity(ctx context.Context, sub T, userInfo *oauth.UserInfo) error {
+	if auth.identities == nil {
+		return errors.New("identity store is not configured")
+	}
+
+	if userInfo.Provider == "" || userInfo.ExternalId == "" {
+		return errors.New("missing provider or external id")
+	}
+
+	identity, err := auth.identities.FindIdentity(ctx, userInfo.Provider, userInfo.ExternalId)
+	if err == nil {
+		if subjectKey(identity.Sub) != subjectKey(sub) {
+			return ErrIdentityAlreadyLinked
+		}
+		return nil
+	}
+	if !errors.Is(err, ErrIdentityNotFound) {
+		return err
+	}
+
+	return auth.identities.LinkIdentity(ctx, Identity[T]{
+		Sub:        sub,
+		Provider:   userInfo.Provider,
+		ExternalId: userInfo.ExternalId,
+		Email:      userInfo.Email,
+		LinkedAt:   time.Now().UTC(),
+	})
+}
+
+// UnlinkIdentity will unlink provider account from a logged-in user.
+func (auth *Auth[T]) UnlinkIdentity(ctx context.Context, sub T, provider string) error {
+	if auth.identities == nil {
+		return errors.New("identity store is not configured")
+	}
+
+	return auth.identities.UnlinkIdentity(ctx, sub, provider)
+}
+
+// ListIdentities will return all provider accounts linked to a user.
+func (auth *Auth[T]) ListIdentities(ctx context.Context, sub T) ([]Identity[T], error) {
+	if auth.identities == nil {
+		return nil, errors.New("identity store is not configured")
+	}
+
+	return auth.identities.ListIdentities(ctx, sub)
+}
+
+// findLinkedUser will find user by linked identity. ErrIdentityNotFound is returned if identity store is not configured.
+func (auth *Auth[T]) findLinkedUser(ctx context.Context, userInfo *oauth.UserInfo) (UserDetails[T], error) {
+	if auth.identities == nil || userInfo.ExternalId == "" {
+		return UserDetails[T]{}, ErrIdentityNotFound
+	}
+
+	identity, err := auth.identities.FindIdentity(ctx, userInfo.Provider, userInfo.ExternalId)
+	if err != nil {
+		return UserDetails[T]{}, err
+	}
+
+	return UserDetails[T]{ID: identity.Sub}, nil
+}
+
+// linkLoggedUser will link provider account to user found by email or provisioned, if identity store is configured.
+func (auth *Auth[T]) linkLoggedUser(ctx context.Context, user UserDetails[T], userInfo *oauth.UserInfo) error {
+	if auth.identities == nil || userInfo.ExternalId == "" {
+		return nil
+	}
+
+	return auth.LinkIdentity(ctx, user.ID, userInfo)
+}
+
+// identityStore is IdentityStore kept in TokenStorage. Identities never expire, so storage must be persistent (redis, sql, bolt).
+type identityStore[T any] struct {
+	storage ContextTokenStorage
+}
+
+// NewIdentityStore will keep linked identities in given storage. Storage must be persistent and must not evict keys.
+// Updates of user's identity index are not atomic, concurrent links of the same user may overwrite each other.
+func NewIdentityStore[T any](storage TokenStorage) IdentityStore[T] {
+	return NewIdentityStoreWithContextStorage[T](StorageWithContext(storage))
+}
+
+// NewIdentityStoreWithContextStorage is NewIdentityStore with context-aware storage, used as is.
+func NewIdentityStoreWithContextStorage[T any](storage ContextTokenStorage) IdentityStore[T] {
+	return &identityStore[T]{
+		storage: storage,
+	}
+}
+
+func (s *identityStore[T]) FindIdentity(ctx context.Context, provider, externalId string) (Identity[T], error) {
+	var identity Identity[T]
+	if err := s.load(ctx, identityKey(provider, externalId), &identity); err != nil {
+		return Identity[T]{}, err
+	}
+
+	return identity, nil
+}
+
+// ListIdentities will load user's identity index from storage backend (WithNoCache), it is updated by read-modify-write.
+func (s *identityStore[T]) ListIdentities(ctx context.Context, sub T) ([]Identity[T], error) {
+	var identities []Identity[T]
+	err := s.load(WithNoCache(ctx), identitiesKey(sub), &identities)
+	if errors.Is(err, ErrIdentityNotFound) {
+		return nil, nil
+	}
+
+	return identities, err
+}
+
+func (s *identityStore[T]) LinkIdentity(ctx context.Context, identity Identity[T]) error {
+	identities, err := s.ListIdentities(ctx, identity.Sub)
+	if err != nil {
+		return err
+	}
+
+	return s.storage.StoreContext(ctx, &Item{
+		Key:   identityKey(identity.Provider, identity.ExternalId),
+		Value: identity,
+	}, &Item{
+		Key:   identitiesKey(identity.Sub),
+		Value: append(identities, identity),
+	})
+}
+
+func (s *identityStore[T]) UnlinkIdentity(ctx context.Context, sub T, provider string) error {
+	identities, err := s.ListIdentities(ctx, sub)
+	if err != nil {
+		return err
+	}
+
+	var keys []string
+	var remaining []Identity[T]
+	for _, identity := range identities {
+		if identity.Provider == provider {
+			keys = append(keys, identityKey(identity.Provider, identity.ExternalId))
+		} else {
+			remaining = append(remaining, identity)
+		}
+	}
+
+	if len(keys) == 0 {
+		return ErrIdentityNotFound
+	}
+
+	if err = s.storage.DeleteContext(ctx, keys...); err != nil {
+		return err
+	}
+
+	if len(remaining) == 0 {
+		return s.storage.DeleteContext(ctx, identitiesKey(sub))
+	}
+
+	return s.storage.StoreContext(ctx, &Item{
+		Key:   identitiesKey(sub),
+		Value: remaining,
+	})
+}
+
+// load will get value from storage and unmarshal it into v. Missing keys are reported as ErrIdentityNotFound.
+func (s *identityStore[T]) load(ctx context.Context, key string, v interface{}) error {
+	b, err := s.storage.LoadContext(ctx, key)
+	if errors.Is(err, ErrStorageUnavailable) {
+		return err
+	}
+	if err != nil {
+		return ErrIdentityNotFound
+	}
+
+	if err = json.Unmarshal(b, v); err != nil {
+		return errors.New("identity unmarshal failed: " + err.Error())
+	}
+
+	return nil
+}
+
+func identityKey(provider, externalId string) string {
+	return identityKeyPrefix + provider + ":" + externalId
+}
+
+func identitiesKey(sub interface{}) string {
+	return identitiesKeyPrefix + subjectKey(sub)
+}
@@ -0,0 +1,223 @@
+package hamr_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/semirm-dev/hamr"
+	"github.com/semirm-dev/hamr/oauth"
+	"github.com/semirm-dev/hamr/storage/memory"
+)
+
+// withIdentities will keep linked identities in in-memory storage, and add github provider which reports no email.
+func withIdentities[T any](t *testing.T) []hamr.Option[T] {
+	storage := memory.New()
+	t.Cleanup(storage.Close)
+
+	github := newTestProvider(t, "github", oauth.UserInfo{
+		ExternalId: "github-1",
+	})
+
+	return []hamr.Option[T]{
+		hamr.WithIdentityStore[T](hamr.NewIdentityStore[T](storage)),
+		hamr.WithProvider[T](github),
+	}
+}
+
+// link will go through account linking flow of logged-in user sub with provider p, started with request to target.
+func link[T any](t *testing.T, auth *hamr.Auth[T], p string, sub T, target string) (hamr.OAuthCallbackResult, error) {
+	r := callbackRequest(t, target, func(w http.ResponseWriter, r *http.Request) error {
+		return auth.OAuthLinkHandler(p, sub, w, r)
+	})
+
+	return auth.OAuthCallbackHandler(context.Background(), p, r)
+}
+
+func TestAuth_LinkedIdentity(t *testing.T) {
+	auth, _ := newAuth[uint](t, 1, withIdentities[uint](t)...)
+	mustLogin(t, auth, "google")
+
+	identities, err := auth.ListIdentities(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identities) != 1 || identities[0].Provider != "google" || identities[0].ExternalId != "google-1" {
+		t.Fatalf("ListIdentities() = %+v, want google account linked on login", identities)
+	}
+}
+
+func TestAuth_OAuthLinkHandler(t *testing.T) {
+	for _, sub := range subjects {
+		t.Run(fmt.Sprint(sub), func(t *testing.T) {
+			auth, _ := newAuth(t, sub, withIdentities[uint64](t)...)
+			mustLogin(t, auth, "google")
+
+			// github reports no email, it can be used only once linked
+			if _, err := login(t, auth, "github"); !errors.Is(err, hamr.ErrUserNotFound) {
+				t.Fatalf("login() with unlinked github error = %v, want hamr.ErrUserNotFound", err)
+			}
+
+			result, err := link(t, auth, "github", sub, "/link?redirect_to=%2Fsettings")
+			if err != nil {
+				t.Fatalf("link() error = %v", err)
+			}
+			if !result.Linked || result.Tokens.AccessToken != "" || result.RedirectTo != "/settings" {
+				t.Fatalf("OAuthCallbackHandler() = %+v, want linked account without tokens", result)
+			}
+
+			td, err := login(t, auth, "github")
+			if err != nil {
+				t.Fatalf("login() with linked github error = %v", err)
+			}
+
+			claims, err := auth.AuthorizedClaims(authorizedRequest(td.AccessToken))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.Subject != sub || claims.Email != "" {
+				t.Errorf("claims = %+v, want linked user %d without email", claims, sub)
+			}
+
+			if _, err = auth.RefreshTokenHandler(context.Background(), td.RefreshToken); err != nil {
+				t.Fatalf("RefreshTokenHandler() without email error = %v", err)
+			}
+
+			identities, err := auth.ListIdentities(context.Background(), sub)
+			if err != nil || len(identities) != 2 {
+				t.Fatalf("ListIdentities() = %+v, %v, want google and github", identities, err)
+			}
+		})
+	}
+}
+
+func TestAuth_OAuthLinkHandler_AlreadyLinked(t *testing.T) {
+	auth, _ := newAuth[uint](t, 1, withIdentities[uint](t)...)
+
+	if _, err := link(t, auth, "github", 2, "/"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := link(t, auth, "github", 1, "/"); !errors.Is(err, hamr.ErrIdentityAlreadyLinked) {
+		t.Fatalf("link() of account linked to other user error = %v, want hamr.ErrIdentityAlreadyLinked", err)
+	}
+
+	// linking the same account again is no-op
+	if _, err := link(t, auth, "github", 2, "/"); err != nil {
+		t.Fatalf("link() of already linked account error = %v", err)
+	}
+}
+
+func TestAuth_OAuthLoginCallbackHandler_RejectsLink(t *testing.T) {
+	auth, _ := newAuth[uint](t, 1, withIdentities[uint](t)...)
+
+	r := callbackRequest(t, "/", func(w http.ResponseWriter, r *http.Request) error {
+		return auth.OAuthLinkHandler("github", 1, w, r)
+	})
+
+	if _, err := auth.OAuthLoginCallbackHandler(context.Background(), "github", r); err == nil {
+		t.Fatal("OAuthLoginCallbackHandler() of linking flow error = nil, want error")
+	}
+
+	identities, err := auth.ListIdentities(context.Background(), 1)
+	if err != nil || len(identities) != 0 {
+		t.Fatalf("ListIdentities() = %+v, %v, want no linked account", identities, err)
+	}
+}
+
+func TestAuth_OAuthLinkHandler_NoIdentityStore(t *testing.T) {
+	auth, _ := newAuth[uint](t, 1)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if err := auth.OAuthLinkHandler("google", 1, httptest.NewRecorder(), r); err == nil {
+		t.Fatal("OAuthLinkHandler() without identity store error = nil, want error")
+	}
+}
+
+func TestAuth_UnlinkIdentity(t *testing.T) {
+	auth, _ := newAuth[uint](t, 1, withIdentities[uint](t)...)
+
+	if _, err := link(t, auth, "github", 1, "/"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := auth.UnlinkIdentity(context.Background(), 1, "github"); err != nil {
+		t.Fatalf("UnlinkIdentity() error = %v", err)
+	}
+
+	if _, err := login(t, auth, "github"); !errors.Is(err, hamr.ErrUserNotFound) {
+		t.Fatalf("login() with unlinked github error = %v, want hamr.ErrUserNotFound", err)
+	}
+
+	if err := auth.UnlinkIdentity(context.Background(), 1, "github"); !errors.Is(err, hamr.ErrIdentityNotFound) {
+		t.Fatalf("second UnlinkIdentity() error = %v, want hamr.ErrIdentityNotFound", err)
+	}
+}
+
+func TestAuth_UnverifiedEmail(t *testing.T) {
+	var looked []oauth.UserInfo
+	var provisioned uint = 100
+
+	gitlab := newTestProvider(t, "gitlab", oauth.UserInfo{
+		ExternalId: "gitlab-1",
+		// the same email as existing user, but not verified by provider
+		Email: "user@example.com",
+	})
+
+	opts := append(withIdentities[uint](t),
+		hamr.WithProvider[uint](gitlab),
+		hamr.WithUserLookup[uint](func(ctx context.Context, userInfo *oauth.UserInfo) (hamr.UserDetails[uint], error) {
+			looked = append(looked, *userInfo)
+			if userInfo.Email == "user@example.com" && userInfo.EmailVerified {
+				return hamr.UserDetails[uint]{ID: 1}, nil
+			}
+			return hamr.UserDetails[uint]{}, hamr.ErrUserNotFound
+		}),
+		hamr.WithUserProvisioning[uint](func(ctx context.Context, userInfo *oauth.UserInfo) (hamr.UserDetails[uint], error) {
+			return hamr.UserDetails[uint]{ID: provisioned}, nil
+		}))
+	auth, _ := newAuth[uint](t, 1, opts...)
+	mustLogin(t, auth, "google")
+
+	td, err := login(t, auth, "gitlab")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := auth.AuthorizedClaims(authorizedRequest(td.AccessToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != provisioned {
+		t.Errorf("Subject = %d, want new user (%d), not the existing user with the same email", claims.Subject, provisioned)
+	}
+	if claims.Email != "" {
+		t.Errorf("Email = %s, want unverified email left out of claims", claims.Email)
+	}
+
+	if len(looked) != 2 || looked[1].Provider != "gitlab" || looked[1].EmailVerified {
+		t.Errorf("lookup called with %+v, want google and unverified gitlab login", looked)
+	}
+
+	identities, err := auth.ListIdentities(context.Background(), 1)
+	if err != nil || len(identities) != 1 || identities[0].Provider != "google" {
+		t.Fatalf("ListIdentities() of existing user = %+v, %v, want only google", identities, err)
+	}
+}
+
+func TestAuth_UnverifiedEmail_GetUserDetails(t *testing.T) {
+	gitlab := newTestProvider(t, "gitlab", oauth.UserInfo{
+		ExternalId: "gitlab-1",
+		Email:      "user@example.com",
+	})
+
+	// GetUserDetailsFunc of newAuth finds user 1 by any email
+	auth, _ := newAuth[uint](t, 1, hamr.WithProvider[uint](gitlab))
+
+	if _, err := login(t, auth, "gitlab"); !errors.Is(err, hamr.ErrUserNotFound) {
+		t.Fatalf("login() with unverified email error = %v, want hamr.ErrUserNotFound", err)
+	}
+}
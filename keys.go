@@ -0,0 +1,190 @@
+package hamr
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"time"
+
+	jwtLib "github.com/golang-jwt/jwt/v4"
+)
+
+// signingKey is used to sign and verify tokens, either with shared HMAC secret or with asymmetric key.
+// Kid is written to token header when key is part of KeySet.
+type signingKey struct {
+	kid       string
+	method    jwtLib.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// VerifyOption configures claims validation of VerifyToken and VerifyTokenByKid.
+type VerifyOption func(*claimsValidator)
+
+// PublicKeyFunc returns public key of token by kid from its header, ex. KeySet.PublicKey or JWKS.PublicKey.
+type PublicKeyFunc func(kid string) (crypto.PublicKey, error)
+
+// WithExpectedIssuer will require token iss claim to be issuer.
+func WithExpectedIssuer(issuer string) VerifyOption {
+	return func(v *claimsValidator) {
+		v.issuer = issuer
+	}
+}
+
+// WithExpectedAudience will require token aud claim to contain at least one of audience.
+func WithExpectedAudience(audience ...string) VerifyOption {
+	return func(v *claimsValidator) {
+		v.audience = audience
+	}
+}
+
+// WithLeeway will allow clock skew when validating exp, nbf and iat claims.
+func WithLeeway(leeway time.Duration) VerifyOption {
+	return func(v *claimsValidator) {
+		v.leeway = leeway
+	}
+}
+
+// VerifyToken will validate token (signature, exp, nbf, iat) with public key of asymmetric signing key and extract its claims.
+// Resource servers can verify access tokens without holding the signing key. Issuer and audience are validated only when given in opts.
+func VerifyToken(token string, publicKey crypto.PublicKey, opts ...VerifyOption) (TokenClaims, error) {
+	key, err := newVerificationKey("", publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return key.parse(token, newVerifyValidator(opts))
+}
+
+// VerifyTokenByKid is VerifyToken with public key selected by kid of token header, so tokens signed with rotated keys
+// of KeySet can be verified.
+func VerifyTokenByKid(token string, publicKey PublicKeyFunc, opts ...VerifyOption) (TokenClaims, error) {
+	return parseToken(token, func(kid string) (*signingKey, error) {
+		key, err := publicKey(kid)
+		if err != nil {
+			return nil, err
+		}
+
+		return newVerificationKey(kid, key)
+	}, newVerifyValidator(opts))
+}
+
+func newVerifyValidator(opts []VerifyOption) *claimsValidator {
+	validator := &claimsValidator{}
+	for _, o := range opts {
+		o(validator)
+	}
+
+	return validator
+}
+
+// newSigningKey will use asymmetric signer if given, otherwise HS256 with shared secret.
+func newSigningKey(secret []byte, signer crypto.Signer) (*signingKey, error) {
+	if signer == nil {
+		if len(secret) == 0 {
+			return nil, errors.New("missing secret key")
+		}
+
+		return &signingKey{
+			method:    jwtLib.SigningMethodHS256,
+			signKey:   secret,
+			verifyKey: secret,
+		}, nil
+	}
+
+	method, err := signingMethod(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	return &signingKey{
+		method:    method,
+		signKey:   signer,
+		verifyKey: signer.Public(),
+	}, nil
+}
+
+// newVerificationKey will create key used only to verify tokens, signing method is selected by public key type.
+func newVerificationKey(kid string, publicKey crypto.PublicKey) (*signingKey, error) {
+	method, err := signingMethod(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &signingKey{
+		kid:       kid,
+		method:    method,
+		verifyKey: publicKey,
+	}, nil
+}
+
+// signingMethod is selected by public key type: RS256 (RSA), ES256/ES384/ES512 (ECDSA by curve), EdDSA (Ed25519).
+func signingMethod(publicKey crypto.PublicKey) (jwtLib.SigningMethod, error) {
+	switch k := publicKey.(type) {
+	case *rsa.PublicKey:
+		return jwtLib.SigningMethodRS256, nil
+	case *ecdsa.PublicKey:
+		switch k.Curve.Params().BitSize {
+		case 256:
+			return jwtLib.SigningMethodES256, nil
+		case 384:
+			return jwtLib.SigningMethodES384, nil
+		case 521:
+			return jwtLib.SigningMethodES512, nil
+		}
+		return nil, fmt.Errorf("unsupported ecdsa curve: %s", k.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return jwtLib.SigningMethodEdDSA, nil
+	}
+
+	return nil, fmt.Errorf("unsupported signing key type: %T", publicKey)
+}
+
+func (k *signingKey) sign(claims TokenClaims) (string, error) {
+	token := jwtLib.NewWithClaims(k.method, jwtLib.MapClaims(claims))
+	if k.kid != "" {
+		token.Header["kid"] = k.kid
+	}
+
+	return token.SignedString(k.signKey)
+}
+
+// parse will validate token signature and extract its claims. Claims are validated only if validator is given.
+func (k *signingKey) parse(token string, validator *claimsValidator) (TokenClaims, error) {
+	return parseToken(token, func(string) (*signingKey, error) {
+		return k, nil
+	}, validator)
+}
+
+// parseToken will validate token signature with the key found by token's kid header and extract its claims.
+// Claims are validated only if validator is given. Numbers are decoded as json.Number, so large numeric subjects keep precision.
+func parseToken(token string, findKey func(kid string) (*signingKey, error), validator *claimsValidator) (TokenClaims, error) {
+	claims := jwtLib.MapClaims{}
+	if _, err := jwtLib.NewParser(jwtLib.WithoutClaimsValidation(), jwtLib.WithJSONNumber()).ParseWithClaims(token, claims, func(t *jwtLib.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+
+		key, err := findKey(kid)
+		if err != nil {
+			return nil, err
+		}
+
+		if t.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %s", t.Method.Alg())
+		}
+
+		return key.verifyKey, nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if validator != nil {
+		if err := validator.validate(TokenClaims(claims)); err != nil {
+			return nil, err
+		}
+	}
+
+	return TokenClaims(claims), nil
+}
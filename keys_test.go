@@ -0,0 +1,145 @@
+package hamr_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	jwtLib "github.com/golang-jwt/jwt/v4"
+
+	"github.com/semirm-dev/hamr"
+)
+
+func TestAuth_SigningKeys(t *testing.T) {
+	tests := map[string]struct {
+		newKey  func() (crypto.Signer, error)
+		wantAlg string
+	}{
+		"RS256": {
+			newKey:  func() (crypto.Signer, error) { return rsa.GenerateKey(rand.Reader, 2048) },
+			wantAlg: "RS256",
+		},
+		"ES256": {
+			newKey:  func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P256(), rand.Reader) },
+			wantAlg: "ES256",
+		},
+		"ES384": {
+			newKey:  func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P384(), rand.Reader) },
+			wantAlg: "ES384",
+		},
+		"ES512": {
+			newKey:  func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P521(), rand.Reader) },
+			wantAlg: "ES512",
+		},
+		"EdDSA": {
+			newKey: func() (crypto.Signer, error) {
+				_, key, err := ed25519.GenerateKey(rand.Reader)
+				return key, err
+			},
+			wantAlg: "EdDSA",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			accessKey, err := tt.newKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+			refreshKey, err := tt.newKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			auth, conf := newAuth[uint](t, 1)
+			conf.AccessTokenSigningKey = accessKey
+			conf.RefreshTokenSigningKey = refreshKey
+			td := mustLogin(t, auth, "google")
+
+			token, _, err := jwtLib.NewParser().ParseUnverified(td.AccessToken, jwtLib.MapClaims{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if alg := token.Header["alg"]; alg != tt.wantAlg {
+				t.Errorf("access token alg = %v, want %s", alg, tt.wantAlg)
+			}
+
+			if err = auth.Authorized(authorizedRequest(td.AccessToken)); err != nil {
+				t.Fatalf("Authorized() error = %v", err)
+			}
+
+			claims, err := hamr.VerifyToken(td.AccessToken, accessKey.Public())
+			if err != nil {
+				t.Fatalf("VerifyToken() error = %v", err)
+			}
+			if claims["email"] != "user@example.com" {
+				t.Errorf("VerifyToken() email = %v, want user@example.com", claims["email"])
+			}
+
+			if _, err = hamr.VerifyToken(td.AccessToken, refreshKey.Public()); err == nil {
+				t.Error("VerifyToken() with other public key error = nil, want error")
+			}
+
+			if _, err = auth.RefreshTokenHandler(context.Background(), td.RefreshToken); err != nil {
+				t.Fatalf("RefreshTokenHandler() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestAuth_SigningKeys_UnsupportedCurve(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth, conf := newAuth[uint](t, 1)
+	conf.AccessTokenSigningKey = key
+
+	if _, err = login(t, auth, "google"); err == nil {
+		t.Fatal("login() with P-224 key error = nil, want error")
+	}
+}
+
+func TestVerifyToken_Options(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth, conf := newAuth[uint](t, 1)
+	conf.AccessTokenSigningKey = key
+	conf.Issuer = "https://auth.example.com"
+	conf.Audience = []string{"api"}
+	td := mustLogin(t, auth, "google")
+
+	conf.AccessTokenExpiry = -time.Second * 5
+	expired := mustLogin(t, auth, "google")
+
+	tests := map[string]struct {
+		token   string
+		opts    []hamr.VerifyOption
+		wantErr bool
+	}{
+		"expected issuer and audience": {token: td.AccessToken, opts: []hamr.VerifyOption{hamr.WithExpectedIssuer("https://auth.example.com"), hamr.WithExpectedAudience("billing", "api")}},
+		"other issuer":                 {token: td.AccessToken, opts: []hamr.VerifyOption{hamr.WithExpectedIssuer("https://other.example.com")}, wantErr: true},
+		"other audience":               {token: td.AccessToken, opts: []hamr.VerifyOption{hamr.WithExpectedAudience("billing")}, wantErr: true},
+		"expired":                      {token: expired.AccessToken, wantErr: true},
+		"expired within leeway":        {token: expired.AccessToken, opts: []hamr.VerifyOption{hamr.WithLeeway(time.Minute)}},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := hamr.VerifyToken(tt.token, key.Public(), tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VerifyToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,342 @@
+package hamr
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+
+	"github.com/semirm-dev/hamr/internal/write"
+)
+
+// KeySet holds active access token signing key and retired keys which are still accepted for verification.
+// Signing keys can be rotated without logging everyone out: tokens signed with retired key stay valid until they expire.
+// Each key is identified by kid, written to token header.
+type KeySet struct {
+	mu        sync.RWMutex
+	activeKid string
+	keys      map[string]*signingKey
+	order     []string
+}
+
+// JWKS is JSON Web Key Set document with public keys of KeySet.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWK is a single public key in JWKS document.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// NewKeySet will create key set with given active signing key.
+func NewKeySet(kid string, signer crypto.Signer) (*KeySet, error) {
+	ks := &KeySet{
+		keys: make(map[string]*signingKey),
+	}
+
+	if err := ks.Rotate(kid, signer); err != nil {
+		return nil, err
+	}
+
+	return ks, nil
+}
+
+// WithKeySet will sign access tokens with active key of key set and verify them by kid.
+// It takes precedence over AccessTokenSecret and AccessTokenSigningKey.
+func WithKeySet[T any](keySet *KeySet) Option[T] {
+	return func(a *Auth[T]) {
+		a.keySet = keySet
+	}
+}
+
+// Rotate will make given key active signing key. Previous active key is retired, it is used only for verification.
+// Kid must be new, keys already in set can not be replaced.
+func (ks *KeySet) Rotate(kid string, signer crypto.Signer) error {
+	if kid == "" || signer == nil {
+		return errors.New("kid and signing key are required")
+	}
+
+	key, err := newSigningKey(nil, signer)
+	if err != nil {
+		return err
+	}
+	key.kid = kid
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if err = ks.add(key); err != nil {
+		return err
+	}
+	ks.activeKid = kid
+
+	return nil
+}
+
+// AddVerificationKey will add retired key, ex. public key of previous deployment, accepted only for verification.
+func (ks *KeySet) AddVerificationKey(kid string, publicKey crypto.PublicKey) error {
+	if kid == "" || publicKey == nil {
+		return errors.New("kid and public key are required")
+	}
+
+	key, err := newVerificationKey(kid, publicKey)
+	if err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	return ks.add(key)
+}
+
+// RemoveKey will remove retired key, once all tokens signed with it have expired. Active key can not be removed.
+func (ks *KeySet) RemoveKey(kid string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if kid == ks.activeKid {
+		return errors.New("active signing key can not be removed")
+	}
+
+	delete(ks.keys, kid)
+	for i, k := range ks.order {
+		if k == kid {
+			ks.order = append(ks.order[:i], ks.order[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+// JWKS returns public keys of all keys in set.
+func (ks *KeySet) JWKS() (JWKS, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	jwks := JWKS{Keys: []JWK{}}
+	for _, kid := range ks.order {
+		jwk, err := newJWK(ks.keys[kid])
+		if err != nil {
+			return JWKS{}, err
+		}
+
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+
+	return jwks, nil
+}
+
+// PublicKey returns public key by kid, it can be used as PublicKeyFunc with VerifyTokenByKid.
+func (ks *KeySet) PublicKey(kid string) (crypto.PublicKey, error) {
+	key, err := ks.verificationKey(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	return key.verifyKey, nil
+}
+
+// PublicKey returns public key of JWK with given kid, it can be used as PublicKeyFunc with VerifyTokenByKid.
+func (jwks JWKS) PublicKey(kid string) (crypto.PublicKey, error) {
+	for _, jwk := range jwks.Keys {
+		if jwk.Kid == kid {
+			return jwk.PublicKey()
+		}
+	}
+
+	return nil, fmt.Errorf("unknown signing key: %s", kid)
+}
+
+// PublicKey will decode JWK into *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey.
+func (jwk JWK) PublicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBase64(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBase64(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported ecdsa curve: %s", jwk.Crv)
+		}
+
+		x, err := decodeBase64(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBase64(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported okp curve: %s", jwk.Crv)
+		}
+
+		x, err := decodeBase64(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 public key size")
+		}
+
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("unsupported jwk key type: %s", jwk.Kty)
+}
+
+// JWKSHandler serves public keys of access tokens as JWKS document, ex. on /.well-known/jwks.json.
+// Resource servers use it to verify access tokens by kid.
+func (auth *Auth[T]) JWKSHandler(w http.ResponseWriter, _ *http.Request) {
+	jwks, err := auth.JWKS()
+	if err != nil {
+		write.JSON(http.StatusInternalServerError, w, map[string]string{"error": err.Error()})
+		return
+	}
+
+	write.JSON(http.StatusOK, w, jwks)
+}
+
+// JWKS returns public keys of access tokens: all keys of KeySet, or AccessTokenSigningKey.
+// Tokens signed with shared secret have no public keys.
+func (auth *Auth[T]) JWKS() (JWKS, error) {
+	if auth.keySet != nil {
+		return auth.keySet.JWKS()
+	}
+
+	jwks := JWKS{Keys: []JWK{}}
+	if auth.conf.AccessTokenSigningKey == nil {
+		return jwks, nil
+	}
+
+	key, err := auth.accessTokenKey()
+	if err != nil {
+		return JWKS{}, err
+	}
+
+	jwk, err := newJWK(key)
+	if err != nil {
+		return JWKS{}, err
+	}
+	jwks.Keys = append(jwks.Keys, jwk)
+
+	return jwks, nil
+}
+
+// signingKey returns active signing key.
+func (ks *KeySet) signingKey() *signingKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	return ks.keys[ks.activeKid]
+}
+
+// verificationKey returns key by kid. Tokens without kid are verified with active key.
+func (ks *KeySet) verificationKey(kid string) (*signingKey, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	if kid == "" {
+		kid = ks.activeKid
+	}
+
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key: %s", kid)
+	}
+
+	return key, nil
+}
+
+// add will add key to set. Existing kid can not be replaced, tokens signed with its key would no longer verify.
+func (ks *KeySet) add(key *signingKey) error {
+	if _, ok := ks.keys[key.kid]; ok {
+		return fmt.Errorf("signing key %s already exists", key.kid)
+	}
+
+	ks.keys[key.kid] = key
+	ks.order = append(ks.order, key.kid)
+
+	return nil
+}
+
+// newJWK will encode public key of signing key as JWK.
+func newJWK(key *signingKey) (JWK, error) {
+	jwk := JWK{
+		Kid: key.kid,
+		Use: "sig",
+		Alg: key.method.Alg(),
+	}
+
+	switch k := key.verifyKey.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = encodeBase64(k.N.Bytes())
+		jwk.E = encodeBase64(big.NewInt(int64(k.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = k.Curve.Params().Name
+		jwk.X = encodeBase64(k.X.FillBytes(make([]byte, size)))
+		jwk.Y = encodeBase64(k.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = encodeBase64(k)
+	default:
+		return JWK{}, fmt.Errorf("unsupported public key type: %T", key.verifyKey)
+	}
+
+	return jwk, nil
+}
+
+func encodeBase64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeBase64(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
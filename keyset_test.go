@@ -0,0 +1,219 @@
+package hamr_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	jwtLib "github.com/golang-jwt/jwt/v4"
+
+	"github.com/semirm-dev/hamr"
+)
+
+func TestKeySet_Rotate(t *testing.T) {
+	oldKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, newKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keySet, err := hamr.NewKeySet("old", oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth, _ := newAuth[uint](t, 1, hamr.WithKeySet[uint](keySet))
+	before := mustLogin(t, auth, "google")
+
+	if err = keySet.Rotate("new", newKey); err != nil {
+		t.Fatal(err)
+	}
+	after := mustLogin(t, auth, "google")
+
+	for kid, td := range map[string]hamr.TokenDetails{"old": before, "new": after} {
+		if got := tokenKid(t, td.AccessToken); got != kid {
+			t.Errorf("access token kid = %s, want %s", got, kid)
+		}
+
+		if err = auth.Authorized(authorizedRequest(td.AccessToken)); err != nil {
+			t.Fatalf("Authorized() with %s key error = %v", kid, err)
+		}
+	}
+
+	if err = keySet.Rotate("old", newKey); err == nil {
+		t.Fatal("Rotate() with existing kid error = nil, want error")
+	}
+	if err = keySet.AddVerificationKey("new", oldKey.Public()); err == nil {
+		t.Fatal("AddVerificationKey() with existing kid error = nil, want error")
+	}
+
+	// failed rotation keeps both keys and active key as they were
+	if got := tokenKid(t, mustLogin(t, auth, "google").AccessToken); got != "new" {
+		t.Errorf("access token kid after failed rotation = %s, want new", got)
+	}
+	if err = auth.Authorized(authorizedRequest(before.AccessToken)); err != nil {
+		t.Fatalf("Authorized() with old key after failed rotation error = %v", err)
+	}
+
+	if err = keySet.RemoveKey("new"); err == nil {
+		t.Fatal("RemoveKey() of active key error = nil, want error")
+	}
+
+	if err = keySet.RemoveKey("old"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = auth.Authorized(authorizedRequest(before.AccessToken)); err == nil {
+		t.Fatal("Authorized() with removed key error = nil, want error")
+	}
+
+	if err = auth.Authorized(authorizedRequest(after.AccessToken)); err != nil {
+		t.Fatalf("Authorized() with active key error = %v", err)
+	}
+}
+
+func TestAuth_JWKSHandler(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keySet, err := hamr.NewKeySet("ec", ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = keySet.Rotate("ed", edKey); err != nil {
+		t.Fatal(err)
+	}
+
+	auth, _ := newAuth[uint](t, 1, hamr.WithKeySet[uint](keySet))
+
+	w := httptest.NewRecorder()
+	auth.JWKSHandler(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("JWKSHandler() status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	var jwks hamr.JWKS
+	if err = json.Unmarshal(w.Body.Bytes(), &jwks); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("JWKSHandler() = %d keys, want 2", len(jwks.Keys))
+	}
+
+	ec, ed := jwks.Keys[0], jwks.Keys[1]
+	if ec.Kid != "ec" || ec.Kty != "EC" || ec.Alg != "ES256" || ec.Crv != "P-256" {
+		t.Errorf("ecdsa jwk = %+v", ec)
+	}
+	if ed.Kid != "ed" || ed.Kty != "OKP" || ed.Alg != "EdDSA" || ed.X != base64.RawURLEncoding.EncodeToString(edPublic) {
+		t.Errorf("ed25519 jwk = %+v", ed)
+	}
+}
+
+func TestVerifyTokenByKid(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keySet, err := hamr.NewKeySet("rsa", rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth, conf := newAuth[uint](t, 1, hamr.WithKeySet[uint](keySet))
+	conf.Audience = []string{"api"}
+
+	tokens := map[string]string{"rsa": mustLogin(t, auth, "google").AccessToken}
+	for kid, key := range map[string]crypto.Signer{"ec": ecKey, "ed": edKey} {
+		if err = keySet.Rotate(kid, key); err != nil {
+			t.Fatal(err)
+		}
+		tokens[kid] = mustLogin(t, auth, "google").AccessToken
+	}
+
+	w := httptest.NewRecorder()
+	auth.JWKSHandler(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+
+	var jwks hamr.JWKS
+	if err = json.Unmarshal(w.Body.Bytes(), &jwks); err != nil {
+		t.Fatal(err)
+	}
+
+	for kid, token := range tokens {
+		for name, publicKey := range map[string]hamr.PublicKeyFunc{"key set": keySet.PublicKey, "jwks": jwks.PublicKey} {
+			claims, err := hamr.VerifyTokenByKid(token, publicKey, hamr.WithExpectedAudience("api"))
+			if err != nil {
+				t.Fatalf("VerifyTokenByKid() of %s token with %s error = %v", kid, name, err)
+			}
+			if claims["email"] != "user@example.com" {
+				t.Errorf("VerifyTokenByKid() email = %v, want user@example.com", claims["email"])
+			}
+
+			if _, err = hamr.VerifyTokenByKid(token, publicKey, hamr.WithExpectedAudience("billing")); err == nil {
+				t.Errorf("VerifyTokenByKid() of %s token with %s and other audience error = nil, want error", kid, name)
+			}
+		}
+	}
+
+	if err = keySet.RemoveKey("rsa"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = hamr.VerifyTokenByKid(tokens["rsa"], keySet.PublicKey); err == nil {
+		t.Fatal("VerifyTokenByKid() with removed key error = nil, want error")
+	}
+}
+
+func TestAuth_JWKS_SharedSecret(t *testing.T) {
+	auth, _ := newAuth[uint](t, 1)
+
+	jwks, err := auth.JWKS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(jwks.Keys) != 0 {
+		t.Fatalf("JWKS() = %+v, want no public keys for shared secret", jwks)
+	}
+}
+
+// tokenKid will read kid header of token, without verifying it.
+func tokenKid(t *testing.T, token string) string {
+	t.Helper()
+
+	parsed, _, err := jwtLib.NewParser().ParseUnverified(token, jwtLib.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kid, _ := parsed.Header["kid"].(string)
+
+	return kid
+}
@@ -2,16 +2,17 @@ package hamr
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/semirm-dev/hamr/oauth"
 )
 
 // OAauthLoginHandler maps to :provider login route. Redirects to :provider oAuth login url.
 func (auth *Auth[T]) OAauthLoginHandler(p string, w http.ResponseWriter, r *http.Request) error {
-	provider := auth.getProvider(p)
-
-	authenticator, err := oauth.NewAuthenticator(auth.conf.authPath, provider)
+	authenticator, err := auth.newAuthenticator(p)
 	if err != nil {
 		return err
 	}
@@ -20,31 +21,211 @@ func (auth *Auth[T]) OAauthLoginHandler(p string, w http.ResponseWriter, r *http
 }
 
 // OAuthLoginCallbackHandler maps to :provider login callback route. After login :provider redirects to this route.
+// Account linking flows (OAuthLinkHandler) are rejected, use OAuthCallbackHandler to handle both.
 func (auth *Auth[T]) OAuthLoginCallbackHandler(ctx context.Context, p string, r *http.Request) (TokenDetails, error) {
-	provider := auth.getProvider(p)
-
-	authenticator, err := oauth.NewAuthenticator(auth.conf.authPath, provider)
+	result, err := auth.oauthCallback(ctx, p, r, false)
 	if err != nil {
 		return TokenDetails{}, err
 	}
 
-	userInfo, err := authenticator.GetUserInfo(ctx, r)
+	return result.Tokens, nil
+}
+
+// OAuthCallbackResult is outcome of OAuth callback, either login (Tokens) or linked provider account (Linked).
+type OAuthCallbackResult struct {
+	// Linked is set when provider account was linked to user who started OAuthLinkHandler, no tokens are issued then.
+	Linked bool
+	Tokens TokenDetails
+	// RedirectTo is local path given as redirect_to when flow started.
+	RedirectTo string
+}
+
+// OAuthCallbackHandler maps to :provider callback route, shared by login and account linking flows.
+// Flow purpose and linking user are taken from server-side login state, never from callback request.
+func (auth *Auth[T]) OAuthCallbackHandler(ctx context.Context, p string, r *http.Request) (OAuthCallbackResult, error) {
+	return auth.oauthCallback(ctx, p, r, true)
+}
+
+func (auth *Auth[T]) oauthCallback(ctx context.Context, p string, r *http.Request, allowLink bool) (OAuthCallbackResult, error) {
+	authenticator, err := auth.newAuthenticator(p)
 	if err != nil {
-		return TokenDetails{}, err
+		return OAuthCallbackResult{}, err
+	}
+
+	userInfo, loginState, err := authenticator.GetUserInfoWithState(ctx, r)
+	if err != nil {
+		return OAuthCallbackResult{}, err
+	}
+
+	result := OAuthCallbackResult{
+		RedirectTo: loginState.RedirectTo,
+	}
+
+	if loginState.Purpose == oauth.PurposeLink {
+		if !allowLink {
+			return OAuthCallbackResult{}, errors.New("unexpected account linking callback")
+		}
+
+		var sub T
+		if err = json.Unmarshal([]byte(loginState.Subject), &sub); err != nil {
+			return OAuthCallbackResult{}, errors.New("invalid subject in oauth state: " + err.Error())
+		}
+
+		if err = auth.LinkIdentity(ctx, sub, userInfo); err != nil {
+			return OAuthCallbackResult{}, err
+		}
+		result.Linked = true
+
+		return result, nil
+	}
+
+	if result.Tokens, err = auth.authenticateWithOAuth(ctx, r, userInfo); err != nil {
+		return OAuthCallbackResult{}, err
 	}
+	result.Tokens.RedirectTo = loginState.RedirectTo
 
-	return auth.authenticateWithOAuth(userInfo)
+	return result, nil
 }
 
 // authenticateWithOAuth will log user with oauth provider (google, github...), save tokens in cache.
-func (auth *Auth[T]) authenticateWithOAuth(userInfo *oauth.UserInfo) (TokenDetails, error) {
-	email := userInfo.Email
+// Login request is used to capture session metadata (client IP, user agent).
+// Login is aborted if user lookup fails. Unknown users are provisioned if UserProvisionFunc is set, otherwise ErrUserNotFound is returned.
+// Users are looked up by linked identity first, then by lookup functions. Found and provisioned users get provider account linked.
+func (auth *Auth[T]) authenticateWithOAuth(ctx context.Context, r *http.Request, userInfo *oauth.UserInfo) (TokenDetails, error) {
+	user, err := auth.findLinkedUser(ctx, userInfo)
+	if errors.Is(err, ErrIdentityNotFound) {
+		user, err = auth.findOrProvisionUser(ctx, userInfo)
+	}
+	if err != nil {
+		return TokenDetails{}, err
+	}
+
+	claims := generateAuthClaims(user.ID, verifiedEmail(userInfo))
 
-	user := auth.getUserDetailsByEmail(email)
+	return auth.createSession(ctx, claims, auth.newSession(r, userInfo.Provider))
+}
+
+// verifiedEmail is email written to token claims. Emails not verified by provider are left out (empty),
+// so services trusting email claim can not be given attacker-controlled email.
+func verifiedEmail(userInfo *oauth.UserInfo) string {
+	if !userInfo.EmailVerified {
+		return ""
+	}
+
+	return userInfo.Email
+}
+
+// findOrProvisionUser will find user without linked identity, or provision a new one, and link its provider account.
+func (auth *Auth[T]) findOrProvisionUser(ctx context.Context, userInfo *oauth.UserInfo) (UserDetails[T], error) {
+	user, err := auth.getUser(ctx, userInfo)
+	if errors.Is(err, ErrUserNotFound) && auth.provisionUser != nil {
+		user, err = auth.provision(ctx, userInfo)
+	}
+	if err != nil {
+		return UserDetails[T]{}, err
+	}
+
+	if err = auth.linkLoggedUser(ctx, user, userInfo); err != nil {
+		return UserDetails[T]{}, err
+	}
+
+	return user, nil
+}
 
-	claims := generateAuthClaims(user.ID, email)
+// getUser will find user of OAuth login with UserLookupFunc if configured, otherwise by email with GetUserDetailsFunc.
+// GetUserDetailsFunc is called only for verified emails (ErrUserNotFound otherwise), so unverified provider account
+// can not be linked to existing user. UserLookupFunc gets every login and must check EmailVerified itself.
+func (auth *Auth[T]) getUser(ctx context.Context, userInfo *oauth.UserInfo) (UserDetails[T], error) {
+	if auth.lookupUser != nil {
+		return auth.lookupUser(ctx, userInfo)
+	}
+
+	if auth.getUserDetailsByEmail == nil {
+		return UserDetails[T]{}, errors.New("user lookup is not configured")
+	}
+
+	if userInfo.Email == "" || !userInfo.EmailVerified {
+		return UserDetails[T]{}, ErrUserNotFound
+	}
+
+	return auth.getUserDetailsByEmail(userInfo.Email), nil
+}
+
+// provision will create user on first login, if its email domain is allowed.
+// Email must be verified when allow or deny list is configured, otherwise any domain could be claimed.
+func (auth *Auth[T]) provision(ctx context.Context, userInfo *oauth.UserInfo) (UserDetails[T], error) {
+	domainListed := len(auth.conf.AllowedEmailDomains) > 0 || len(auth.conf.DeniedEmailDomains) > 0
+	if domainListed && !userInfo.EmailVerified {
+		return UserDetails[T]{}, ErrEmailDomainNotAllowed
+	}
+
+	if !auth.emailDomainAllowed(userInfo.Email) {
+		return UserDetails[T]{}, ErrEmailDomainNotAllowed
+	}
+
+	return auth.provisionUser(ctx, userInfo)
+}
+
+// emailDomainAllowed will check email domain against deny list first, then against allow list. Domains are matched case-insensitively.
+// Any email, including empty one, is allowed when no lists are configured.
+func (auth *Auth[T]) emailDomainAllowed(email string) bool {
+	if len(auth.conf.AllowedEmailDomains) == 0 && len(auth.conf.DeniedEmailDomains) == 0 {
+		return true
+	}
+
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := email[at+1:]
+
+	for _, d := range auth.conf.DeniedEmailDomains {
+		if strings.EqualFold(d, domain) {
+			return false
+		}
+	}
+
+	if len(auth.conf.AllowedEmailDomains) == 0 {
+		return true
+	}
+
+	for _, d := range auth.conf.AllowedEmailDomains {
+		if strings.EqualFold(d, domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// OAuthLinkHandler maps to :provider link route of logged-in user (sub). Redirects to :provider oAuth login url,
+// provider account is linked to sub by OAuthCallbackHandler. Requires identity store and OAuth state store.
+func (auth *Auth[T]) OAuthLinkHandler(p string, sub T, w http.ResponseWriter, r *http.Request) error {
+	if auth.identities == nil {
+		return errors.New("identity store is not configured")
+	}
+
+	subject, err := json.Marshal(sub)
+	if err != nil {
+		return err
+	}
+
+	authenticator, err := auth.newAuthenticator(p)
+	if err != nil {
+		return err
+	}
+
+	return authenticator.RedirectToLinkUrl(w, r, string(subject))
+}
+
+// newAuthenticator will set up oauth.Authenticator for :provider, login state is kept in state store.
+func (auth *Auth[T]) newAuthenticator(p string) (*oauth.Authenticator, error) {
+	var opts []oauth.AuthenticatorOption
+	if auth.stateStore != nil {
+		opts = append(opts, oauth.WithStateStore(auth.stateStore))
+	}
 
-	return auth.createSession(claims)
+	return oauth.NewAuthenticator(auth.conf.authPath, auth.getProvider(p), opts...)
 }
 
 func (auth *Auth[T]) getProvider(providerName string) oauth.Provider {
@@ -0,0 +1,163 @@
+package hamr_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/semirm-dev/hamr"
+	"github.com/semirm-dev/hamr/oauth"
+)
+
+func TestAuth_UserLookup(t *testing.T) {
+	var looked []*oauth.UserInfo
+	auth, _ := newAuth[uint](t, 1, hamr.WithUserLookup[uint](func(ctx context.Context, userInfo *oauth.UserInfo) (hamr.UserDetails[uint], error) {
+		looked = append(looked, userInfo)
+		return hamr.UserDetails[uint]{ID: 42}, nil
+	}))
+	td := mustLogin(t, auth, "google")
+
+	if len(looked) != 1 || looked[0].Provider != "google" || looked[0].Email != "user@example.com" {
+		t.Fatalf("lookup called with %+v, want google profile", looked)
+	}
+
+	claims, err := auth.AuthorizedClaims(authorizedRequest(td.AccessToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != 42 {
+		t.Errorf("Subject = %d, want user found by lookup (42)", claims.Subject)
+	}
+}
+
+func TestAuth_UserLookup_ExternalId(t *testing.T) {
+	// github account without public email
+	github := newTestProvider(t, "github", oauth.UserInfo{
+		ExternalId: "github-1",
+	})
+
+	auth, _ := newAuth[uint](t, 1,
+		hamr.WithProvider[uint](github),
+		hamr.WithUserLookup[uint](func(ctx context.Context, userInfo *oauth.UserInfo) (hamr.UserDetails[uint], error) {
+			if userInfo.Provider == "github" && userInfo.ExternalId == "github-1" {
+				return hamr.UserDetails[uint]{ID: 42}, nil
+			}
+			return hamr.UserDetails[uint]{}, hamr.ErrUserNotFound
+		}))
+
+	for i := 0; i < 2; i++ {
+		td := mustLogin(t, auth, "github")
+
+		claims, err := auth.AuthorizedClaims(authorizedRequest(td.AccessToken))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claims.Subject != 42 {
+			t.Errorf("Subject = %d, want user found by external id (42)", claims.Subject)
+		}
+	}
+}
+
+func TestAuth_UserLookup_Errors(t *testing.T) {
+	backendErr := errors.New("users database down")
+
+	tests := map[string]struct {
+		lookupErr error
+		wantErr   error
+	}{
+		"user not found":         {lookupErr: hamr.ErrUserNotFound, wantErr: hamr.ErrUserNotFound},
+		"wrapped user not found": {lookupErr: fmt.Errorf("no user: %w", hamr.ErrUserNotFound), wantErr: hamr.ErrUserNotFound},
+		"lookup failed":          {lookupErr: backendErr, wantErr: backendErr},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			auth, _ := newAuth[uint](t, 1, hamr.WithUserLookup[uint](func(ctx context.Context, userInfo *oauth.UserInfo) (hamr.UserDetails[uint], error) {
+				return hamr.UserDetails[uint]{}, tt.lookupErr
+			}))
+
+			if _, err := login(t, auth, "google"); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("login() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuth_UserProvisioning(t *testing.T) {
+	tests := map[string]struct {
+		email         string
+		emailVerified bool
+		allowed       []string
+		denied        []string
+		wantErr       error
+	}{
+		"no domain lists":             {email: "new@gmail.com", emailVerified: true},
+		"no email without lists":      {email: ""},
+		"allowed domain":              {email: "new@Example.com", emailVerified: true, allowed: []string{"example.com"}},
+		"not allowed domain":          {email: "new@gmail.com", emailVerified: true, allowed: []string{"example.com"}, wantErr: hamr.ErrEmailDomainNotAllowed},
+		"unverified allowed domain":   {email: "new@example.com", allowed: []string{"example.com"}, wantErr: hamr.ErrEmailDomainNotAllowed},
+		"denied domain":               {email: "new@spam.com", emailVerified: true, denied: []string{"SPAM.com"}, wantErr: hamr.ErrEmailDomainNotAllowed},
+		"unverified with denied list": {email: "new@example.com", denied: []string{"spam.com"}, wantErr: hamr.ErrEmailDomainNotAllowed},
+		"denied before allowed":       {email: "new@example.com", emailVerified: true, allowed: []string{"example.com"}, denied: []string{"example.com"}, wantErr: hamr.ErrEmailDomainNotAllowed},
+		"no email with allowed list":  {email: "", allowed: []string{"example.com"}, wantErr: hamr.ErrEmailDomainNotAllowed},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var provisioned []*oauth.UserInfo
+			github := newTestProvider(t, "github", oauth.UserInfo{
+				ExternalId:    "github-1",
+				Email:         tt.email,
+				EmailVerified: tt.emailVerified,
+			})
+
+			auth, conf := newAuth[uint](t, 1,
+				hamr.WithProvider[uint](github),
+				hamr.WithUserLookup[uint](func(ctx context.Context, userInfo *oauth.UserInfo) (hamr.UserDetails[uint], error) {
+					return hamr.UserDetails[uint]{}, hamr.ErrUserNotFound
+				}),
+				hamr.WithUserProvisioning[uint](func(ctx context.Context, userInfo *oauth.UserInfo) (hamr.UserDetails[uint], error) {
+					provisioned = append(provisioned, userInfo)
+					return hamr.UserDetails[uint]{ID: 100}, nil
+				}))
+			conf.AllowedEmailDomains = tt.allowed
+			conf.DeniedEmailDomains = tt.denied
+
+			td, err := login(t, auth, "github")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("login() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr != nil {
+				if len(provisioned) != 0 {
+					t.Fatalf("provisioned %+v, want no user", provisioned)
+				}
+				return
+			}
+
+			if len(provisioned) != 1 {
+				t.Fatalf("provisioned %d users, want 1", len(provisioned))
+			}
+
+			claims, err := auth.AuthorizedClaims(authorizedRequest(td.AccessToken))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.Subject != 100 {
+				t.Errorf("Subject = %d, want provisioned user (100)", claims.Subject)
+			}
+		})
+	}
+}
+
+func TestAuth_UserProvisioning_ExistingUser(t *testing.T) {
+	auth, conf := newAuth[uint](t, 1, hamr.WithUserProvisioning[uint](func(ctx context.Context, userInfo *oauth.UserInfo) (hamr.UserDetails[uint], error) {
+		t.Fatal("existing user must not be provisioned")
+		return hamr.UserDetails[uint]{}, nil
+	}))
+	// lists apply only to new users
+	conf.DeniedEmailDomains = []string{"example.com"}
+
+	mustLogin(t, auth, "google")
+}
@@ -0,0 +1,16 @@
+package hamr
+
+import (
+	"net/http"
+)
+
+// LogoutHandler will revoke access token from request's Authorization headers together with its refresh token.
+// Returns ErrTokenRevoked if session was already revoked and ErrTokenExpired if session has expired.
+func (auth *Auth[T]) LogoutHandler(r *http.Request) error {
+	accessToken, err := getAccessTokenFromRequest(r)
+	if err != nil {
+		return err
+	}
+
+	return auth.destroySession(r.Context(), accessToken)
+}
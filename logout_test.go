@@ -0,0 +1,60 @@
+package hamr_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/semirm-dev/hamr"
+)
+
+func TestAuth_LogoutHandler(t *testing.T) {
+	auth, _ := newAuth[uint](t, 1)
+	td := mustLogin(t, auth, "google")
+
+	if err := auth.LogoutHandler(authorizedRequest(td.AccessToken)); err != nil {
+		t.Fatalf("LogoutHandler() error = %v", err)
+	}
+
+	if err := auth.Authorized(authorizedRequest(td.AccessToken)); err == nil {
+		t.Fatal("Authorized() after logout error = nil, want error")
+	}
+
+	if _, err := auth.RefreshTokenHandler(context.Background(), td.RefreshToken); err == nil {
+		t.Fatal("RefreshTokenHandler() after logout error = nil, want error")
+	}
+
+	if err := auth.LogoutHandler(authorizedRequest(td.AccessToken)); !errors.Is(err, hamr.ErrTokenRevoked) {
+		t.Fatalf("second LogoutHandler() error = %v, want hamr.ErrTokenRevoked", err)
+	}
+}
+
+func TestAuth_LogoutHandler_ExpiredAccessToken(t *testing.T) {
+	auth, conf := newAuth[uint](t, 1)
+	conf.AccessTokenExpiry = -time.Minute
+	td := mustLogin(t, auth, "google")
+
+	// expired access token still logs out its session
+	if err := auth.LogoutHandler(authorizedRequest(td.AccessToken)); err != nil {
+		t.Fatalf("LogoutHandler() error = %v", err)
+	}
+
+	if _, err := auth.RefreshTokenHandler(context.Background(), td.RefreshToken); err == nil {
+		t.Fatal("RefreshTokenHandler() after logout error = nil, want error")
+	}
+
+	if err := auth.LogoutHandler(authorizedRequest(td.AccessToken)); !errors.Is(err, hamr.ErrTokenExpired) {
+		t.Fatalf("second LogoutHandler() error = %v, want hamr.ErrTokenExpired", err)
+	}
+}
+
+func TestAuth_LogoutHandler_MissingToken(t *testing.T) {
+	auth, _ := newAuth[uint](t, 1)
+
+	if err := auth.LogoutHandler(httptest.NewRequest(http.MethodPost, "/logout", nil)); err == nil {
+		t.Fatal("LogoutHandler() error = nil, want error")
+	}
+}
@@ -16,12 +16,18 @@ func (auth *Auth[T]) Authorized(r *http.Request) error {
 	return err
 }
 
+// AuthorizedClaims middleware will check if the request is authorized and return its typed claims.
+func (auth *Auth[T]) AuthorizedClaims(r *http.Request) (*Claims[T], error) {
+	return auth.authorize(r)
+}
+
 // AuthorizedWithCasbin middleware will check if the request is authorized applying Casbin policy too.
 func (auth *Auth[T]) AuthorizedWithCasbin(obj, act, policy string, adapter *gormadapter.Adapter, r *http.Request) error {
-	id, err := auth.authorize(r)
+	claims, err := auth.authorize(r)
 	if err != nil {
 		return err
 	}
+	id := fmt.Sprint(claims.Subject)
 
 	if policyOk, policyErr := enforce(id, obj, act, policy, adapter); policyErr != nil || !policyOk {
 		return errors.New(fmt.Sprintf("casbin policy not passed, err: %s", policyErr))
@@ -30,21 +36,22 @@ func (auth *Auth[T]) AuthorizedWithCasbin(obj, act, policy string, adapter *gorm
 	return nil
 }
 
-func (auth *Auth[T]) authorize(r *http.Request) (interface{}, error) {
-	claims, err := auth.GetClaimsFromRequest(r)
+// authorize will validate access token from request against cache storage and return its typed claims.
+func (auth *Auth[T]) authorize(r *http.Request) (*Claims[T], error) {
+	ctx := r.Context()
+
+	claims, err := auth.GetTypedClaimsFromRequest(r)
 	if err != nil {
 		return nil, err
 	}
 
-	userIdFromRequestClaims := claims["sub"]
-	accessTokenUuid := claims["uuid"]
-	if userIdFromRequestClaims == nil || accessTokenUuid == nil {
-		return nil, errors.New("userId or accessTokenUuid is nil")
+	if claims.TokenId == "" {
+		return nil, errors.New("accessTokenUuid is missing")
 	}
 
-	accessTokenCached, err := auth.getTokenFromCache(accessTokenUuid.(string))
+	accessTokenCached, err := auth.getTokenFromCache(ctx, claims.TokenId)
 	if err != nil {
-		return nil, errors.New(fmt.Sprintf("failed to get access token from cache: %s", err))
+		return nil, fmt.Errorf("failed to get access token from cache: %w", err)
 	}
 
 	userIdFromCacheClaims, ok := accessTokenCached["sub"]
@@ -52,11 +59,27 @@ func (auth *Auth[T]) authorize(r *http.Request) (interface{}, error) {
 		return nil, errors.New("sub not found in accessTokenCached")
 	}
 
-	if userIdFromRequestClaims.(float64) != userIdFromCacheClaims.(float64) {
+	if !sameSubject(claims.Subject, userIdFromCacheClaims) {
 		return nil, errors.New("userIdFromRequestClaims does not match userIdFromCacheClaims")
 	}
 
-	return userIdFromRequestClaims, nil
+	if auth.conf.IdleTimeout > 0 || auth.conf.AbsoluteSessionLifetime > 0 {
+		familyId, ok := accessTokenCached["family_id"].(string)
+		if !ok {
+			return nil, errors.New("family_id not found in accessTokenCached")
+		}
+
+		session, err := auth.getSessionFromCache(ctx, familyId)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = auth.touchSession(ctx, session); err != nil {
+			return nil, err
+		}
+	}
+
+	return claims, nil
 }
 
 func enforce(sub any, obj, act, policy string, adapter *gormadapter.Adapter) (bool, error) {
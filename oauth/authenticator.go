@@ -6,6 +6,7 @@ import (
 	"encoding/base64"
 	"errors"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -15,12 +16,17 @@ import (
 const (
 	oAuthLoginAntiForgeryKey = "externalLoginAntiForgery"
 	stateExpiry              = time.Minute * 2
+	// stateSeparator separates state and PKCE code verifier in anti-forgery cookie, both are base64 url encoded.
+	// Used only without StateStore.
+	stateSeparator = "."
 )
 
 // Authenticator is responsible for oauth logins, oAuth2 configuration setup.
 type Authenticator struct {
-	provider Provider
-	conf     *oauth2.Config
+	provider     Provider
+	conf         *oauth2.Config
+	stateStore   StateStore
+	secureCookie bool
 }
 
 // Provider specific requirements.
@@ -40,20 +46,28 @@ type Credentials interface {
 
 // UserInfo from oauth provider.
 type UserInfo struct {
+	Provider   string
 	ExternalId string
 	Email      string
+	// EmailVerified is set if provider reports Email as verified.
+	EmailVerified bool
 }
 
 // NewAuthenticator will set up Authenticator, oAuth2 configuration.
-func NewAuthenticator(baseAuthPath string, provider Provider) (*Authenticator, error) {
+func NewAuthenticator(baseAuthPath string, provider Provider, opts ...AuthenticatorOption) (*Authenticator, error) {
+	if provider == nil {
+		return nil, errors.New("unknown oauth provider")
+	}
+
 	redirectUrl := baseAuthPath + "/" + provider.Name() + "/callback"
 
-	return newAuthenticator(provider, redirectUrl)
+	return newAuthenticator(provider, redirectUrl, opts...)
 }
 
-func newAuthenticator(provider Provider, redirectUrl string) (*Authenticator, error) {
+func newAuthenticator(provider Provider, redirectUrl string, opts ...AuthenticatorOption) (*Authenticator, error) {
 	auth := &Authenticator{
-		provider: provider,
+		provider:     provider,
+		secureCookie: strings.HasPrefix(redirectUrl, "https://"),
 		conf: &oauth2.Config{
 			ClientID:     provider.ClientId(),
 			ClientSecret: provider.ClientSecret(),
@@ -63,17 +77,71 @@ func newAuthenticator(provider Provider, redirectUrl string) (*Authenticator, er
 		},
 	}
 
+	for _, o := range opts {
+		o(auth)
+	}
+
 	return auth, nil
 }
 
 // RedirectToLoginUrl from oauth provider.
+// PKCE code verifier is generated per login and bound to anti-forgery state, only its S256 challenge is sent to provider.
+// With StateStore, login state (verifier, nonce, redirect_to target) is saved server-side and cookie holds only the state.
 func (a *Authenticator) RedirectToLoginUrl(w http.ResponseWriter, r *http.Request) error {
-	oAuthState, err := setLoginAntiForgeryCookie(w)
+	return a.redirect(w, r, &LoginState{})
+}
+
+// RedirectToLinkUrl from oauth provider, when logged-in user (subject) links another provider account. Requires StateStore:
+// subject is saved server-side with PurposeLink and returned by GetUserInfoWithState, so callback can not be used to link other users.
+func (a *Authenticator) RedirectToLinkUrl(w http.ResponseWriter, r *http.Request, subject string) error {
+	if a.stateStore == nil {
+		return errors.New("state store is required to link accounts")
+	}
+
+	return a.redirect(w, r, &LoginState{
+		Purpose: PurposeLink,
+		Subject: subject,
+	})
+}
+
+// redirect will save login state and redirect to oauth provider's login url.
+func (a *Authenticator) redirect(w http.ResponseWriter, r *http.Request, loginState *LoginState) error {
+	oAuthState, err := randomString()
 	if err != nil {
 		return err
 	}
 
-	oAuthLoginUrl := a.conf.AuthCodeURL(oAuthState)
+	loginState.ExpiresAt = time.Now().Add(stateExpiry)
+
+	var opts []oauth2.AuthCodeOption
+	if a.pkceEnabled() {
+		if loginState.Verifier, err = generateVerifier(); err != nil {
+			return err
+		}
+
+		opts = append(opts,
+			oauth2.SetAuthURLParam("code_challenge", codeChallenge(loginState.Verifier)),
+			oauth2.SetAuthURLParam("code_challenge_method", codeChallengeMethod))
+	}
+
+	cookieValue := oAuthState
+	if a.stateStore != nil {
+		if loginState.Nonce, err = randomString(); err != nil {
+			return err
+		}
+		opts = append(opts, oauth2.SetAuthURLParam("nonce", loginState.Nonce))
+		loginState.RedirectTo = redirectTarget(r.FormValue("redirect_to"))
+
+		if err = a.stateStore.Save(r.Context(), oAuthState, loginState, stateExpiry); err != nil {
+			return err
+		}
+	} else if loginState.Verifier != "" {
+		cookieValue += stateSeparator + loginState.Verifier
+	}
+
+	a.setLoginAntiForgeryCookie(w, cookieValue, loginState.ExpiresAt)
+
+	oAuthLoginUrl := a.conf.AuthCodeURL(oAuthState, opts...)
 
 	http.Redirect(w, r, oAuthLoginUrl, http.StatusTemporaryRedirect)
 	return nil
@@ -81,57 +149,91 @@ func (a *Authenticator) RedirectToLoginUrl(w http.ResponseWriter, r *http.Reques
 
 // GetUserInfo from oauth provider.
 func (a *Authenticator) GetUserInfo(ctx context.Context, r *http.Request) (*UserInfo, error) {
-	token, err := a.exchangeCodeForToken(ctx, r)
+	userInfo, _, err := a.GetUserInfoWithState(ctx, r)
+	return userInfo, err
+}
+
+// GetUserInfoWithState from oauth provider, together with login state saved when login started (ex. redirect_to target).
+// Without StateStore, login state holds only PKCE code verifier.
+func (a *Authenticator) GetUserInfoWithState(ctx context.Context, r *http.Request) (*UserInfo, *LoginState, error) {
+	token, loginState, err := a.exchangeCodeForToken(ctx, r)
 	if err != nil {
 		logrus.Errorf("failed to exchange code for token: %v", err)
-		return nil, errors.New("failed to get token from oauth provider")
+		return nil, nil, errors.New("failed to get token from oauth provider")
 	}
 
 	userInfo, err := a.provider.GetUserInfo(token.AccessToken)
 	if err != nil {
 		logrus.Errorf("failed to get user info from oauth provider: %v", err)
-		return nil, errors.New("failed to get user info from oauth provider")
+		return nil, nil, errors.New("failed to get user info from oauth provider")
 	}
+	userInfo.Provider = a.provider.Name()
 
-	return userInfo, nil
+	return userInfo, loginState, nil
 }
 
 // exchangeCodeForToken will validate state and exchange code for oauth token.
-func (a *Authenticator) exchangeCodeForToken(ctx context.Context, r *http.Request) (*oauth2.Token, error) {
+// PKCE code verifier bound to the state is sent with the code. Server-side state is removed, so it can not be used again.
+func (a *Authenticator) exchangeCodeForToken(ctx context.Context, r *http.Request) (*oauth2.Token, *LoginState, error) {
 	oAuthStateSaved, oAuthStateErr := r.Cookie(oAuthLoginAntiForgeryKey)
 	oAuthState := r.FormValue("state")
 	oAuthStateCode := r.FormValue("code")
 
 	if oAuthStateErr != nil || oAuthState == "" {
-		return nil, errors.New("invalid oAuthStateSaved/oAuthState")
+		return nil, nil, errors.New("invalid oAuthStateSaved/oAuthState")
+	}
+
+	savedState, verifier, _ := strings.Cut(oAuthStateSaved.Value, stateSeparator)
+	if oAuthState != savedState {
+		return nil, nil, errors.New("oAuthState do not match")
+	}
+
+	loginState := &LoginState{Verifier: verifier}
+	if a.stateStore != nil {
+		var err error
+		if loginState, err = a.takeLoginState(ctx, oAuthState); err != nil {
+			return nil, nil, err
+		}
 	}
 
-	if oAuthState != oAuthStateSaved.Value {
-		return nil, errors.New("oAuthState do not match")
+	var opts []oauth2.AuthCodeOption
+	if a.pkceEnabled() {
+		if loginState.Verifier == "" {
+			return nil, nil, errors.New("missing pkce code verifier")
+		}
+		opts = append(opts, oauth2.SetAuthURLParam("code_verifier", loginState.Verifier))
 	}
 
-	token, err := a.conf.Exchange(ctx, oAuthStateCode)
+	token, err := a.conf.Exchange(ctx, oAuthStateCode, opts...)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return token, nil
+	return token, loginState, nil
 }
 
-// setLoginAntiForgeryCookie will generate random state string and save it in cookies.
-// This is for CSRF protection.
-func setLoginAntiForgeryCookie(w http.ResponseWriter) (string, error) {
+// setLoginAntiForgeryCookie will save state in cookies, it binds login to the browser which started it.
+// This is for CSRF protection. Cookie is not readable by scripts and is sent on top-level redirect back from provider.
+func (a *Authenticator) setLoginAntiForgeryCookie(w http.ResponseWriter, value string, expiration time.Time) {
+	cookie := http.Cookie{
+		Name:     oAuthLoginAntiForgeryKey,
+		Value:    value,
+		Path:     "/",
+		Expires:  expiration,
+		HttpOnly: true,
+		Secure:   a.secureCookie,
+		SameSite: http.SameSiteLaxMode,
+	}
+	http.SetCookie(w, &cookie)
+}
+
+// randomString will generate random string, used for state and nonce.
+func randomString() (string, error) {
 	b := make([]byte, 16)
 	_, err := rand.Read(b)
 	if err != nil {
 		return "", err
 	}
 
-	state := base64.URLEncoding.EncodeToString(b)
-	var expiration = time.Now().Add(stateExpiry)
-
-	cookie := http.Cookie{Name: oAuthLoginAntiForgeryKey, Value: state, Expires: expiration}
-	http.SetCookie(w, &cookie)
-
-	return state, nil
+	return base64.URLEncoding.EncodeToString(b), nil
 }
@@ -0,0 +1,194 @@
+package oauth_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"golang.org/x/oauth2"
+
+	"github.com/semirm-dev/hamr/oauth"
+)
+
+// testProvider is Provider backed by test token server, which records code verifier of each code exchange.
+type testProvider struct {
+	server    *httptest.Server
+	mu        sync.Mutex
+	verifiers []string
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	p := &testProvider{}
+	p.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		p.mu.Lock()
+		p.verifiers = append(p.verifiers, r.Form.Get("code_verifier"))
+		p.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "provider-token", "token_type": "bearer"})
+	}))
+	t.Cleanup(p.server.Close)
+
+	return p
+}
+
+func (p *testProvider) ClientId() string     { return "client-id" }
+func (p *testProvider) ClientSecret() string { return "client-secret" }
+func (p *testProvider) Name() string         { return "test" }
+func (p *testProvider) Scopes() []string     { return []string{"email"} }
+
+func (p *testProvider) Endpoint() oauth2.Endpoint {
+	return oauth2.Endpoint{
+		AuthURL:  p.server.URL + "/auth",
+		TokenURL: p.server.URL + "/token",
+	}
+}
+
+func (p *testProvider) GetUserInfo(string) (*oauth.UserInfo, error) {
+	return &oauth.UserInfo{ExternalId: "external-1", Email: "user@example.com", EmailVerified: true}, nil
+}
+
+func (p *testProvider) lastVerifier() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.verifiers) == 0 {
+		return ""
+	}
+
+	return p.verifiers[len(p.verifiers)-1]
+}
+
+// noPKCEProvider opted out of PKCE.
+type noPKCEProvider struct {
+	*testProvider
+}
+
+func (p *noPKCEProvider) SupportsPKCE() bool { return false }
+
+// startLogin will redirect to provider's login url and return it together with callback request carrying its state and cookie.
+func startLogin(t *testing.T, a *oauth.Authenticator, target string) (*url.URL, *http.Request) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	if err := a.RedirectToLoginUrl(w, httptest.NewRequest(http.MethodGet, target, nil)); err != nil {
+		t.Fatalf("RedirectToLoginUrl() error = %v", err)
+	}
+
+	return callback(t, w)
+}
+
+// callback will follow redirect to provider and return provider's redirect back to callback route.
+func callback(t *testing.T, w *httptest.ResponseRecorder) (*url.URL, *http.Request) {
+	t.Helper()
+
+	loginUrl, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/callback?code=code&state="+url.QueryEscape(loginUrl.Query().Get("state")), nil)
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+
+	return loginUrl, r
+}
+
+func TestAuthenticator_PKCE(t *testing.T) {
+	p := newTestProvider(t)
+	a, err := oauth.NewAuthenticator("http://localhost:8080/auth", p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loginUrl, r := startLogin(t, a, "/")
+	challenge := loginUrl.Query().Get("code_challenge")
+	if challenge == "" || loginUrl.Query().Get("code_challenge_method") != "S256" {
+		t.Fatalf("login url = %s, want S256 code challenge", loginUrl)
+	}
+
+	if _, err = a.GetUserInfo(context.Background(), r); err != nil {
+		t.Fatalf("GetUserInfo() error = %v", err)
+	}
+
+	verifier := p.lastVerifier()
+	if len(verifier) < 43 {
+		t.Fatalf("code_verifier = %q, want at least 43 characters", verifier)
+	}
+
+	h := sha256.Sum256([]byte(verifier))
+	if got := base64.RawURLEncoding.EncodeToString(h[:]); got != challenge {
+		t.Errorf("S256(code_verifier) = %s, want code_challenge %s", got, challenge)
+	}
+}
+
+func TestAuthenticator_PKCE_UniquePerLogin(t *testing.T) {
+	a, err := oauth.NewAuthenticator("http://localhost:8080/auth", newTestProvider(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, _ := startLogin(t, a, "/")
+	second, _ := startLogin(t, a, "/")
+
+	if first.Query().Get("code_challenge") == second.Query().Get("code_challenge") {
+		t.Fatal("code_challenge is reused between logins")
+	}
+}
+
+func TestAuthenticator_PKCE_OptOut(t *testing.T) {
+	p := newTestProvider(t)
+	a, err := oauth.NewAuthenticator("http://localhost:8080/auth", &noPKCEProvider{p})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loginUrl, r := startLogin(t, a, "/")
+	if loginUrl.Query().Has("code_challenge") || loginUrl.Query().Has("code_challenge_method") {
+		t.Fatalf("login url = %s, want no code challenge", loginUrl)
+	}
+
+	if _, err = a.GetUserInfo(context.Background(), r); err != nil {
+		t.Fatalf("GetUserInfo() error = %v", err)
+	}
+
+	if verifier := p.lastVerifier(); verifier != "" {
+		t.Errorf("code_verifier = %q, want none", verifier)
+	}
+}
+
+func TestAuthenticator_GetUserInfo_InvalidState(t *testing.T) {
+	a, err := oauth.NewAuthenticator("http://localhost:8080/auth", newTestProvider(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, r := startLogin(t, a, "/")
+
+	forged := httptest.NewRequest(http.MethodGet, "/callback?code=code&state=forged", nil)
+	for _, cookie := range r.Cookies() {
+		forged.AddCookie(cookie)
+	}
+
+	noCookie := httptest.NewRequest(http.MethodGet, r.URL.String(), nil)
+
+	for name, r := range map[string]*http.Request{"forged state": forged, "missing cookie": noCookie} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := a.GetUserInfo(context.Background(), r); err == nil {
+				t.Fatal("GetUserInfo() error = nil, want error")
+			}
+		})
+	}
+}
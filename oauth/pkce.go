@@ -0,0 +1,44 @@
+package oauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+const (
+	codeChallengeMethod = "S256"
+	verifierLength      = 32
+)
+
+// PKCESupport can be implemented by Provider to opt out of PKCE, ex. provider rejects code_challenge parameters.
+// Providers not implementing it use PKCE.
+type PKCESupport interface {
+	SupportsPKCE() bool
+}
+
+// pkceEnabled reports whether PKCE is used with provider.
+func (a *Authenticator) pkceEnabled() bool {
+	if p, ok := a.provider.(PKCESupport); ok {
+		return p.SupportsPKCE()
+	}
+
+	return true
+}
+
+// generateVerifier will generate random PKCE code verifier (RFC 7636), 43 characters long.
+func generateVerifier() (string, error) {
+	b := make([]byte, verifierLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// codeChallenge is S256 challenge of code verifier.
+func codeChallenge(verifier string) string {
+	h := sha256.Sum256([]byte(verifier))
+
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
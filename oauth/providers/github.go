@@ -24,6 +24,12 @@ type githubResponse struct {
 	Email string `json:"email"`
 }
 
+type githubEmail struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
 func NewGitHub(clientId, clientSecret string) *GitHub {
 	return &GitHub{
 		clientId:     clientId,
@@ -51,20 +57,51 @@ func (p *GitHub) Endpoint() oauth2.Endpoint {
 	return github.Endpoint
 }
 
+// GetUserInfo will get user profile. Public profile email is often empty and carries no verification status,
+// so email is taken from user's emails: primary verified email, or profile email if it is verified.
 func (p *GitHub) GetUserInfo(accessToken string) (*oauth.UserInfo, error) {
-	exchangeUrl := "https://api.github.com/user"
+	r := &githubResponse{}
+	if err := p.get("https://api.github.com/user", accessToken, r); err != nil {
+		return nil, err
+	}
 
-	req, err := http.NewRequest("GET", exchangeUrl, nil)
-	if err != nil {
+	var emails []githubEmail
+	if err := p.get("https://api.github.com/user/emails", accessToken, &emails); err != nil {
 		return nil, err
 	}
 
+	userInfo := &oauth.UserInfo{
+		ExternalId: fmt.Sprint(r.Id),
+		Email:      r.Email,
+	}
+
+	for _, e := range emails {
+		if !e.Verified {
+			continue
+		}
+
+		if e.Email == r.Email || (e.Primary && r.Email == "") {
+			userInfo.Email = e.Email
+			userInfo.EmailVerified = true
+			break
+		}
+	}
+
+	return userInfo, nil
+}
+
+func (p *GitHub) get(url, accessToken string, v interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+
 	req.Header.Add("Authorization", "token "+accessToken)
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer func() {
 		err = resp.Body.Close()
@@ -74,18 +111,14 @@ func (p *GitHub) GetUserInfo(accessToken string) (*oauth.UserInfo, error) {
 		}
 	}()
 
-	contents, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("github api %s responded with status %d", url, resp.StatusCode)
 	}
 
-	r := &githubResponse{}
-	if err = json.Unmarshal(contents, &r); err != nil {
-		return nil, err
+	contents, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
 	}
 
-	return &oauth.UserInfo{
-		ExternalId: fmt.Sprint(r.Id),
-		Email:      r.Email,
-	}, nil
+	return json.Unmarshal(contents, v)
 }
@@ -19,8 +19,9 @@ type Google struct {
 }
 
 type googleResponse struct {
-	Id    string `json:"id"`
-	Email string `json:"email"`
+	Id            string `json:"id"`
+	Email         string `json:"email"`
+	VerifiedEmail bool   `json:"verified_email"`
 }
 
 func NewGoogle(clientId, clientSecret string) *Google {
@@ -76,7 +77,8 @@ func (p *Google) GetUserInfo(accessToken string) (*oauth.UserInfo, error) {
 	}
 
 	return &oauth.UserInfo{
-		ExternalId: r.Id,
-		Email:      r.Email,
+		ExternalId:    r.Id,
+		Email:         r.Email,
+		EmailVerified: r.VerifiedEmail,
 	}, nil
 }
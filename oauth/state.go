@@ -0,0 +1,76 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrStateNotFound should be returned by StateStore when state does not exist, was already used or has expired.
+var ErrStateNotFound = errors.New("oauth state not found")
+
+// PurposeLink marks login state of a flow started by logged-in user to link another provider account.
+const PurposeLink = "link"
+
+// LoginState is saved server-side for each login, keyed by anti-forgery state.
+type LoginState struct {
+	// Verifier is PKCE code verifier, empty if provider opted out of PKCE.
+	Verifier string `json:"verifier"`
+	// Nonce is sent to provider as nonce parameter. It is not verified, user info is read from provider's user info api, not from id_token.
+	Nonce string `json:"nonce"`
+	// RedirectTo is local path user is sent to after login.
+	RedirectTo string `json:"redirect_to"`
+	// Purpose is PurposeLink for account linking flow, empty for login.
+	Purpose string `json:"purpose,omitempty"`
+	// Subject is user who started account linking flow, set only with PurposeLink.
+	Subject   string    `json:"subject,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// StateStore keeps LoginState server-side. Take must delete the state, so each state can be used only once.
+type StateStore interface {
+	Save(ctx context.Context, state string, loginState *LoginState, expiry time.Duration) error
+	Take(ctx context.Context, state string) (*LoginState, error)
+}
+
+// AuthenticatorOption configures Authenticator.
+type AuthenticatorOption func(*Authenticator)
+
+// WithStateStore will keep login state server-side in store, anti-forgery cookie holds only the state.
+// Without it PKCE code verifier is kept in the cookie and redirect-after-login target is not supported.
+func WithStateStore(store StateStore) AuthenticatorOption {
+	return func(a *Authenticator) {
+		a.stateStore = store
+	}
+}
+
+// takeLoginState will get and remove login state from store. Expired state is rejected.
+func (a *Authenticator) takeLoginState(ctx context.Context, state string) (*LoginState, error) {
+	loginState, err := a.stateStore.Take(ctx, state)
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(loginState.ExpiresAt) {
+		return nil, ErrStateNotFound
+	}
+
+	return loginState, nil
+}
+
+// redirectTarget will get redirect-after-login target from redirect_to parameter.
+// Only local paths are accepted, so login can not be used as open redirect.
+func redirectTarget(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return ""
+	}
+
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return ""
+	}
+
+	return target
+}
@@ -0,0 +1,197 @@
+package oauth_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/semirm-dev/hamr/oauth"
+)
+
+func TestAuthenticator_StateStore(t *testing.T) {
+	p := newTestProvider(t)
+	store := newMapStore()
+	a, err := oauth.NewAuthenticator("http://localhost:8080/auth", p, oauth.WithStateStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loginUrl, r := startLogin(t, a, "/")
+	state := loginUrl.Query().Get("state")
+
+	cookies := r.Cookies()
+	if len(cookies) != 1 || cookies[0].Value != state {
+		t.Fatalf("cookies = %v, want only state %s", cookies, state)
+	}
+
+	saved := store.get(state)
+	if saved == nil || saved.Verifier == "" || saved.Nonce == "" {
+		t.Fatalf("saved login state = %+v, want verifier and nonce", saved)
+	}
+	if nonce := loginUrl.Query().Get("nonce"); nonce != saved.Nonce {
+		t.Errorf("nonce = %s, want saved nonce %s", nonce, saved.Nonce)
+	}
+
+	if _, err = a.GetUserInfo(context.Background(), r); err != nil {
+		t.Fatalf("GetUserInfo() error = %v", err)
+	}
+	if verifier := p.lastVerifier(); verifier != saved.Verifier {
+		t.Errorf("code_verifier = %s, want saved verifier %s", verifier, saved.Verifier)
+	}
+
+	// state is single-use, replayed callback is rejected
+	if _, err = a.GetUserInfo(context.Background(), r); err == nil {
+		t.Fatal("GetUserInfo() with used state error = nil, want error")
+	}
+}
+
+func TestAuthenticator_StateStore_Expired(t *testing.T) {
+	store := newMapStore()
+	a, err := oauth.NewAuthenticator("http://localhost:8080/auth", newTestProvider(t), oauth.WithStateStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loginUrl, r := startLogin(t, a, "/")
+	store.get(loginUrl.Query().Get("state")).ExpiresAt = time.Now().Add(-time.Second)
+
+	if _, err = a.GetUserInfo(context.Background(), r); err == nil {
+		t.Fatal("GetUserInfo() with expired state error = nil, want error")
+	}
+}
+
+func TestAuthenticator_RedirectTo(t *testing.T) {
+	tests := map[string]string{
+		"/dashboard?tab=1":   "/dashboard?tab=1",
+		"":                   "",
+		"https://evil.com":   "",
+		"//evil.com":         "",
+		"/\\evil.com":        "",
+		"javascript:alert()": "",
+	}
+
+	for target, want := range tests {
+		t.Run(target, func(t *testing.T) {
+			a, err := oauth.NewAuthenticator("http://localhost:8080/auth", newTestProvider(t), oauth.WithStateStore(newMapStore()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, r := startLogin(t, a, "/login?redirect_to="+url.QueryEscape(target))
+
+			_, loginState, err := a.GetUserInfoWithState(context.Background(), r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if loginState.RedirectTo != want {
+				t.Errorf("RedirectTo = %q, want %q", loginState.RedirectTo, want)
+			}
+		})
+	}
+}
+
+func TestAuthenticator_AntiForgeryCookie(t *testing.T) {
+	for authPath, wantSecure := range map[string]bool{
+		"http://localhost:8080/auth": false,
+		"https://example.com/auth":   true,
+	} {
+		t.Run(authPath, func(t *testing.T) {
+			a, err := oauth.NewAuthenticator(authPath, newTestProvider(t), oauth.WithStateStore(newMapStore()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := httptest.NewRecorder()
+			if err = a.RedirectToLoginUrl(w, httptest.NewRequest(http.MethodGet, "/", nil)); err != nil {
+				t.Fatal(err)
+			}
+
+			cookies := w.Result().Cookies()
+			if len(cookies) != 1 {
+				t.Fatalf("cookies = %v, want anti-forgery cookie", cookies)
+			}
+
+			cookie := cookies[0]
+			if !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode || cookie.Path != "/" || cookie.Secure != wantSecure {
+				t.Errorf("cookie = %+v, want HttpOnly, SameSite=Lax, Path=/ and Secure=%v", cookie, wantSecure)
+			}
+		})
+	}
+}
+
+func TestAuthenticator_RedirectToLinkUrl(t *testing.T) {
+	a, err := oauth.NewAuthenticator("http://localhost:8080/auth", newTestProvider(t), oauth.WithStateStore(newMapStore()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	if err = a.RedirectToLinkUrl(w, httptest.NewRequest(http.MethodGet, "/", nil), "42"); err != nil {
+		t.Fatalf("RedirectToLinkUrl() error = %v", err)
+	}
+	_, r := callback(t, w)
+
+	_, loginState, err := a.GetUserInfoWithState(context.Background(), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loginState.Purpose != oauth.PurposeLink || loginState.Subject != "42" {
+		t.Errorf("login state = %+v, want link purpose and subject 42", loginState)
+	}
+}
+
+func TestAuthenticator_RedirectToLinkUrl_NoStateStore(t *testing.T) {
+	a, err := oauth.NewAuthenticator("http://localhost:8080/auth", newTestProvider(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = a.RedirectToLinkUrl(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil), "42"); err == nil {
+		t.Fatal("RedirectToLinkUrl() without state store error = nil, want error")
+	}
+}
+
+// mapStore is in-memory StateStore.
+type mapStore struct {
+	mu     sync.Mutex
+	states map[string]*oauth.LoginState
+}
+
+func newMapStore() *mapStore {
+	return &mapStore{states: make(map[string]*oauth.LoginState)}
+}
+
+func (s *mapStore) Save(_ context.Context, state string, loginState *oauth.LoginState, _ time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.states[state] = loginState
+
+	return nil
+}
+
+func (s *mapStore) Take(_ context.Context, state string) (*oauth.LoginState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	loginState, ok := s.states[state]
+	if !ok {
+		return nil, oauth.ErrStateNotFound
+	}
+	delete(s.states, state)
+
+	return loginState, nil
+}
+
+func (s *mapStore) get(state string) *oauth.LoginState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.states[state]
+}
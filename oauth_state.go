@@ -0,0 +1,72 @@
+package hamr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/semirm-dev/hamr/oauth"
+)
+
+const oauthStateKeyPrefix = "oauth_state:"
+
+// oauthStateStore is oauth.StateStore kept in TokenStorage.
+type oauthStateStore struct {
+	storage ContextTokenStorage
+}
+
+// NewOAuthStateStore will keep OAuth login state in given storage. State expires together with the login.
+// Take is load followed by delete, concurrent callbacks with the same state are not strictly single-use.
+func NewOAuthStateStore(storage TokenStorage) oauth.StateStore {
+	return NewOAuthStateStoreWithContextStorage(StorageWithContext(storage))
+}
+
+// NewOAuthStateStoreWithContextStorage is NewOAuthStateStore with context-aware storage, used as is.
+func NewOAuthStateStoreWithContextStorage(storage ContextTokenStorage) oauth.StateStore {
+	return &oauthStateStore{
+		storage: storage,
+	}
+}
+
+// WithOAuthStateStore will keep OAuth login state in store. By default, login state is kept in auth TokenStorage.
+func WithOAuthStateStore[T any](store oauth.StateStore) Option[T] {
+	return func(a *Auth[T]) {
+		a.stateStore = store
+	}
+}
+
+func (s *oauthStateStore) Save(ctx context.Context, state string, loginState *oauth.LoginState, expiry time.Duration) error {
+	return s.storage.StoreContext(ctx, &Item{
+		Key:        oauthStateKey(state),
+		Value:      loginState,
+		Expiration: expiry,
+	})
+}
+
+func (s *oauthStateStore) Take(ctx context.Context, state string) (*oauth.LoginState, error) {
+	key := oauthStateKey(state)
+
+	b, err := s.storage.LoadContext(ctx, key)
+	if errors.Is(err, ErrStorageUnavailable) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, oauth.ErrStateNotFound
+	}
+
+	if err = s.storage.DeleteContext(ctx, key); err != nil {
+		return nil, err
+	}
+
+	loginState := &oauth.LoginState{}
+	if err = json.Unmarshal(b, loginState); err != nil {
+		return nil, errors.New("oauth state unmarshal failed: " + err.Error())
+	}
+
+	return loginState, nil
+}
+
+func oauthStateKey(state string) string {
+	return oauthStateKeyPrefix + state
+}
@@ -0,0 +1,76 @@
+package hamr_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/semirm-dev/hamr"
+	"github.com/semirm-dev/hamr/oauth"
+	"github.com/semirm-dev/hamr/storage/memory"
+)
+
+func TestAuth_OAuthLoginCallbackHandler_RedirectTo(t *testing.T) {
+	auth, _ := newAuth[uint](t, 1)
+
+	r := callbackRequest(t, "/login?redirect_to=%2Forders%2F7", func(w http.ResponseWriter, r *http.Request) error {
+		return auth.OAauthLoginHandler("google", w, r)
+	})
+
+	td, err := auth.OAuthLoginCallbackHandler(context.Background(), "google", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if td.RedirectTo != "/orders/7" {
+		t.Errorf("RedirectTo = %q, want /orders/7", td.RedirectTo)
+	}
+}
+
+func TestAuth_OAuthLoginCallbackHandler_Replay(t *testing.T) {
+	auth, _ := newAuth[uint](t, 1)
+
+	r := callbackRequest(t, "/", func(w http.ResponseWriter, r *http.Request) error {
+		return auth.OAauthLoginHandler("google", w, r)
+	})
+
+	if _, err := auth.OAuthLoginCallbackHandler(context.Background(), "google", r); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := auth.OAuthLoginCallbackHandler(context.Background(), "google", r); err == nil {
+		t.Fatal("replayed OAuthLoginCallbackHandler() error = nil, want error")
+	}
+}
+
+func TestOAuthStateStore(t *testing.T) {
+	storage := memory.New()
+	defer storage.Close()
+
+	store := hamr.NewOAuthStateStore(storage)
+	ctx := context.Background()
+
+	saved := &oauth.LoginState{
+		Verifier:   "verifier",
+		Nonce:      "nonce",
+		RedirectTo: "/home",
+		ExpiresAt:  time.Now().Add(time.Minute).UTC().Truncate(time.Second),
+	}
+	if err := store.Save(ctx, "state", saved, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	loginState, err := store.Take(ctx, "state")
+	if err != nil {
+		t.Fatalf("Take() error = %v", err)
+	}
+	if *loginState != *saved {
+		t.Errorf("Take() = %+v, want %+v", loginState, saved)
+	}
+
+	if _, err = store.Take(ctx, "state"); !errors.Is(err, oauth.ErrStateNotFound) {
+		t.Fatalf("second Take() error = %v, want oauth.ErrStateNotFound", err)
+	}
+}
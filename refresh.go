@@ -0,0 +1,87 @@
+package hamr
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// RefreshTokenReuseEvent is emitted when already rotated refresh token is used again.
+// It usually means refresh token was stolen, so whole refresh token family gets revoked.
+type RefreshTokenReuseEvent[T any] struct {
+	Sub              T
+	FamilyId         string
+	RefreshTokenUuid string
+	DetectedAt       time.Time
+}
+
+type RefreshTokenReuseFunc[T any] func(RefreshTokenReuseEvent[T])
+
+// RefreshTokenHandler will validate given refresh token and issue a new pair of access and refresh tokens.
+// Refresh tokens are rotated: new refresh token belongs to the same family and old access token is removed from cache.
+// Presenting already rotated refresh token revokes the whole family and returns ErrRefreshTokenReused.
+// Reuse check and rotation are separate TokenStorage reads and writes, not a compare-and-swap: two concurrent refreshes
+// with the same refresh token may both succeed. The later write wins the family, the other new refresh token is then treated as reused.
+func (auth *Auth[T]) RefreshTokenHandler(ctx context.Context, refreshToken string) (TokenDetails, error) {
+	refreshTokenClaims, err := auth.extractRefreshTokenClaims(refreshToken)
+	if err != nil {
+		return TokenDetails{}, err
+	}
+
+	claims, err := decodeClaims[T](refreshTokenClaims)
+	if err != nil {
+		return TokenDetails{}, err
+	}
+
+	refreshTokenUuid := claims.TokenId
+	if refreshTokenUuid == "" {
+		return TokenDetails{}, errors.New("invalid claims from refresh_token")
+	}
+
+	refreshTokenCached, err := auth.getTokenFromCache(ctx, refreshTokenUuid)
+	if err != nil {
+		return TokenDetails{}, err
+	}
+
+	if !sameSubject(claims.Subject, refreshTokenCached["sub"]) {
+		return TokenDetails{}, errors.New("sub from refresh_token does not match cached refresh_token")
+	}
+
+	familyId, ok := refreshTokenCached["family_id"].(string)
+	if !ok {
+		return TokenDetails{}, errors.New("family_id not found in cached refresh_token")
+	}
+
+	session, err := auth.getSessionFromCache(ctx, familyId)
+	if err != nil {
+		return TokenDetails{}, err
+	}
+
+	if session.RefreshTokenUuid != refreshTokenUuid {
+		if err = auth.revokeSession(ctx, session); err != nil {
+			return TokenDetails{}, err
+		}
+
+		if auth.onRefreshTokenReuse != nil {
+			auth.onRefreshTokenReuse(RefreshTokenReuseEvent[T]{
+				Sub:              claims.Subject,
+				FamilyId:         familyId,
+				RefreshTokenUuid: refreshTokenUuid,
+				DetectedAt:       time.Now().UTC(),
+			})
+		}
+
+		return TokenDetails{}, ErrRefreshTokenReused
+	}
+
+	if err = auth.touchSession(ctx, session); err != nil {
+		return TokenDetails{}, err
+	}
+
+	// rotated refresh token stays in cache until it expires, so its reuse can be detected
+	if err = auth.storage.DeleteContext(ctx, session.AccessTokenUuid); err != nil {
+		return TokenDetails{}, err
+	}
+
+	return auth.issueTokens(ctx, generateAuthClaims(claims.Subject, claims.Email), session.Session)
+}
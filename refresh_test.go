@@ -0,0 +1,94 @@
+package hamr_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/semirm-dev/hamr"
+)
+
+func TestAuth_RefreshTokenHandler(t *testing.T) {
+	auth, _ := newAuth[uint](t, 1)
+	td := mustLogin(t, auth, "google")
+
+	refreshed, err := auth.RefreshTokenHandler(context.Background(), td.RefreshToken)
+	if err != nil {
+		t.Fatalf("RefreshTokenHandler() error = %v", err)
+	}
+
+	if refreshed.AccessToken == "" || refreshed.RefreshToken == "" {
+		t.Fatalf("RefreshTokenHandler() = %+v, want access and refresh token", refreshed)
+	}
+
+	if err = auth.Authorized(authorizedRequest(refreshed.AccessToken)); err != nil {
+		t.Fatalf("Authorized() with refreshed access token error = %v", err)
+	}
+}
+
+func TestAuth_RefreshTokenHandler_InvalidToken(t *testing.T) {
+	auth, _ := newAuth[uint](t, 1)
+	td := mustLogin(t, auth, "google")
+
+	for name, token := range map[string]string{
+		"empty":        "",
+		"malformed":    "not-a-token",
+		"access token": td.AccessToken,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := auth.RefreshTokenHandler(context.Background(), token); err == nil {
+				t.Fatal("RefreshTokenHandler() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestAuth_RefreshTokenHandler_Rotation(t *testing.T) {
+	auth, _ := newAuth[uint](t, 1)
+	td := mustLogin(t, auth, "google")
+
+	refreshed, err := auth.RefreshTokenHandler(context.Background(), td.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = auth.Authorized(authorizedRequest(td.AccessToken)); err == nil {
+		t.Fatal("Authorized() with rotated access token error = nil, want error")
+	}
+
+	// rotated refresh token keeps rotating within the same session
+	if _, err = auth.RefreshTokenHandler(context.Background(), refreshed.RefreshToken); err != nil {
+		t.Fatalf("RefreshTokenHandler() with rotated refresh token error = %v", err)
+	}
+}
+
+func TestAuth_RefreshTokenHandler_Reuse(t *testing.T) {
+	var sub uint64 = 1 << 60
+	var events []hamr.RefreshTokenReuseEvent[uint64]
+	auth, _ := newAuth(t, sub, hamr.WithRefreshTokenReuseHandler[uint64](func(e hamr.RefreshTokenReuseEvent[uint64]) {
+		events = append(events, e)
+	}))
+	td := mustLogin(t, auth, "google")
+
+	refreshed, err := auth.RefreshTokenHandler(context.Background(), td.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = auth.RefreshTokenHandler(context.Background(), td.RefreshToken); !errors.Is(err, hamr.ErrRefreshTokenReused) {
+		t.Fatalf("RefreshTokenHandler() with reused refresh token error = %v, want hamr.ErrRefreshTokenReused", err)
+	}
+
+	if len(events) != 1 || events[0].FamilyId == "" || events[0].Sub != sub {
+		t.Fatalf("reuse events = %+v, want 1 event of user %d with family id", events, sub)
+	}
+
+	// whole family is revoked, including tokens issued by the legitimate refresh
+	if _, err = auth.RefreshTokenHandler(context.Background(), refreshed.RefreshToken); err == nil {
+		t.Fatal("RefreshTokenHandler() after reuse error = nil, want error")
+	}
+
+	if err = auth.Authorized(authorizedRequest(refreshed.AccessToken)); err == nil {
+		t.Fatal("Authorized() after reuse error = nil, want error")
+	}
+}
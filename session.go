@@ -0,0 +1,322 @@
+package hamr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	familyKeyPrefix   = "family:"
+	sessionsKeyPrefix = "sessions:"
+	idleKeyPrefix     = "idle:"
+)
+
+// Session is a single login session of a user.
+// It is created on login and shared by all tokens rotated from its refresh token, session ID is refresh token family ID.
+type Session struct {
+	ID        string    `json:"id"`
+	Provider  string    `json:"provider"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// cachedSession is saved in cache together with the latest pair of access and refresh tokens.
+// SubKey is canonical string form of Sub, written once when tokens are issued, session index key is built from it.
+type cachedSession struct {
+	Session
+	Sub              interface{} `json:"sub"`
+	SubKey           string      `json:"sub_key"`
+	AccessTokenUuid  string      `json:"access_token_uuid"`
+	RefreshTokenUuid string      `json:"refresh_token_uuid"`
+}
+
+// newSession will create a new login session from login request.
+// Each login session starts a new refresh token family.
+func (auth *Auth[T]) newSession(r *http.Request, provider string) Session {
+	return Session{
+		ID:        uuid.New().String(),
+		Provider:  provider,
+		IP:        auth.clientIP(r),
+		UserAgent: r.UserAgent(),
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// ListSessions will return all active sessions of given user.
+func (auth *Auth[T]) ListSessions(ctx context.Context, sub T) ([]Session, error) {
+	activeSessions, err := auth.getActiveSessions(ctx, subjectKey(sub))
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []Session
+	for _, session := range activeSessions {
+		sessions = append(sessions, session.Session)
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession will remove given session of a user together with its access and refresh tokens.
+func (auth *Auth[T]) RevokeSession(ctx context.Context, sub T, sessionId string) error {
+	session, err := auth.getSessionFromCache(ctx, sessionId)
+	if errors.Is(err, ErrTokenRevoked) {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if session.subjectKey() != subjectKey(sub) {
+		return ErrSessionNotFound
+	}
+
+	return auth.revokeSession(ctx, session)
+}
+
+// RevokeAllSessions will remove all sessions of a user together with their access and refresh tokens.
+// Useful to log user out everywhere, ex. after password change.
+func (auth *Auth[T]) RevokeAllSessions(ctx context.Context, sub T) error {
+	subKey := subjectKey(sub)
+
+	activeSessions, err := auth.getActiveSessions(ctx, subKey)
+	if err != nil {
+		return err
+	}
+
+	keys := []string{sessionsKey(subKey)}
+	for _, session := range activeSessions {
+		keys = append(keys, session.keys()...)
+	}
+
+	return auth.storage.DeleteContext(ctx, keys...)
+}
+
+// touchSession will validate session idle timeout and absolute lifetime, and extend session's idle deadline.
+// Idle deadline is kept in cache under its own key with IdleTimeout expiration, missing key means session was idle for too long.
+// Expired session is revoked and ErrSessionExpired returned.
+func (auth *Auth[T]) touchSession(ctx context.Context, session *cachedSession) error {
+	expired := auth.conf.AbsoluteSessionLifetime > 0 && time.Since(session.CreatedAt) > auth.conf.AbsoluteSessionLifetime
+
+	if !expired && auth.conf.IdleTimeout > 0 {
+		_, err := auth.storage.LoadContext(ctx, idleKey(session.ID))
+		if errors.Is(err, ErrStorageUnavailable) {
+			return err
+		}
+		expired = err != nil
+	}
+
+	if expired {
+		if err := auth.revokeSession(ctx, session); err != nil {
+			return err
+		}
+		return ErrSessionExpired
+	}
+
+	if auth.conf.IdleTimeout > 0 {
+		return auth.storage.StoreContext(ctx, auth.idleItem(session.ID))
+	}
+
+	return nil
+}
+
+// idleItem will set session's idle deadline to IdleTimeout from now.
+func (auth *Auth[T]) idleItem(sessionId string) *Item {
+	return &Item{
+		Key:        idleKey(sessionId),
+		Value:      time.Now().UTC(),
+		Expiration: auth.conf.IdleTimeout,
+	}
+}
+
+// enforceSessionLimit will apply session limit policy before a new session of a user is created.
+// RejectNewSession returns SessionLimitError, EvictOldestSession revokes the oldest sessions to make room for the new one.
+func (auth *Auth[T]) enforceSessionLimit(ctx context.Context, subKey string) error {
+	if auth.conf.MaxSessions <= 0 {
+		return nil
+	}
+
+	activeSessions, err := auth.getActiveSessions(ctx, subKey)
+	if err != nil {
+		return err
+	}
+
+	overLimit := len(activeSessions) - auth.conf.MaxSessions + 1
+	if overLimit <= 0 {
+		return nil
+	}
+
+	if auth.conf.SessionLimitPolicy != EvictOldestSession {
+		return &SessionLimitError{Limit: auth.conf.MaxSessions}
+	}
+
+	sort.Slice(activeSessions, func(i, j int) bool {
+		return activeSessions[i].CreatedAt.Before(activeSessions[j].CreatedAt)
+	})
+
+	for _, session := range activeSessions[:overLimit] {
+		if err = auth.revokeSession(ctx, session); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getActiveSessions will get all sessions of a user from cache. Expired sessions are removed from session index.
+func (auth *Auth[T]) getActiveSessions(ctx context.Context, subKey string) ([]*cachedSession, error) {
+	sessionIds, err := auth.getSessionIndex(ctx, subKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []*cachedSession
+	var activeIds []string
+	for _, sessionId := range sessionIds {
+		session, err := auth.getSessionFromCache(ctx, sessionId)
+		if errors.Is(err, ErrTokenRevoked) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, session)
+		activeIds = append(activeIds, sessionId)
+	}
+
+	if len(activeIds) != len(sessionIds) {
+		if err := auth.storeSessionIndex(ctx, subKey, activeIds); err != nil {
+			return nil, err
+		}
+	}
+
+	return sessions, nil
+}
+
+// getSessionFromCache will get session (refresh token family) from cache.
+func (auth *Auth[T]) getSessionFromCache(ctx context.Context, sessionId string) (*cachedSession, error) {
+	session := &cachedSession{}
+	if err := auth.loadFromCache(ctx, familyKey(sessionId), session); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// revokeSession will remove session and its latest pair of access and refresh tokens from cache and session index.
+func (auth *Auth[T]) revokeSession(ctx context.Context, session *cachedSession) error {
+	if err := auth.storage.DeleteContext(ctx, session.keys()...); err != nil {
+		return err
+	}
+
+	return auth.removeFromSessionIndex(ctx, session.subjectKey(), session.ID)
+}
+
+// addToSessionIndex will add session to user's session index. Index expiry is extended each time.
+func (auth *Auth[T]) addToSessionIndex(ctx context.Context, subKey string, sessionId string) error {
+	sessionIds, err := auth.getSessionIndex(ctx, subKey)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range sessionIds {
+		if id == sessionId {
+			return auth.storeSessionIndex(ctx, subKey, sessionIds)
+		}
+	}
+
+	return auth.storeSessionIndex(ctx, subKey, append(sessionIds, sessionId))
+}
+
+// removeFromSessionIndex will remove session from user's session index.
+func (auth *Auth[T]) removeFromSessionIndex(ctx context.Context, subKey string, sessionId string) error {
+	sessionIds, err := auth.getSessionIndex(ctx, subKey)
+	if err != nil {
+		return err
+	}
+
+	var remaining []string
+	for _, id := range sessionIds {
+		if id != sessionId {
+			remaining = append(remaining, id)
+		}
+	}
+
+	if len(remaining) == 0 {
+		return auth.storage.DeleteContext(ctx, sessionsKey(subKey))
+	}
+
+	return auth.storeSessionIndex(ctx, subKey, remaining)
+}
+
+// getSessionIndex will get session ids of a user. Missing index means user has no sessions.
+// Index is always loaded from storage backend (WithNoCache), stale index of local cache would drop sessions of other instances on update.
+func (auth *Auth[T]) getSessionIndex(ctx context.Context, subKey string) ([]string, error) {
+	var sessionIds []string
+	err := auth.loadFromCache(WithNoCache(ctx), sessionsKey(subKey), &sessionIds)
+	if errors.Is(err, ErrTokenRevoked) {
+		return nil, nil
+	}
+
+	return sessionIds, err
+}
+
+// storeSessionIndex will save session ids of a user. Index lives as long as the latest refresh token.
+func (auth *Auth[T]) storeSessionIndex(ctx context.Context, subKey string, sessionIds []string) error {
+	return auth.storage.StoreContext(ctx, &Item{
+		Key:        sessionsKey(subKey),
+		Value:      sessionIds,
+		Expiration: auth.conf.RefreshTokenExpiry,
+	})
+}
+
+// keys of session and its latest pair of access and refresh tokens.
+func (s *cachedSession) keys() []string {
+	return []string{familyKey(s.ID), idleKey(s.ID), s.AccessTokenUuid, s.RefreshTokenUuid}
+}
+
+func familyKey(familyId string) string {
+	return familyKeyPrefix + familyId
+}
+
+func idleKey(sessionId string) string {
+	return idleKeyPrefix + sessionId
+}
+
+// subjectKey of session, sessions cached before SubKey was introduced fall back to their Sub.
+func (s *cachedSession) subjectKey() string {
+	if s.SubKey != "" {
+		return s.SubKey
+	}
+
+	return subjectKey(s.Sub)
+}
+
+// sessionsKey is user's session index key, built from canonical sub (see subjectKey).
+func sessionsKey(subKey string) string {
+	return sessionsKeyPrefix + subKey
+}
+
+// subjectKey is canonical string form of sub, so typed sub (ex. uint 1000000) and the same sub decoded
+// from json (float64 1e+06, json.Number) match.
+func subjectKey(sub interface{}) string {
+	switch s := sub.(type) {
+	case float64:
+		return strconv.FormatFloat(s, 'f', -1, 64)
+	case json.Number:
+		return s.String()
+	}
+
+	return fmt.Sprint(sub)
+}
@@ -0,0 +1,323 @@
+package hamr_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/semirm-dev/hamr"
+	"github.com/semirm-dev/hamr/storage/memory"
+	"github.com/semirm-dev/hamr/storage/tiered"
+)
+
+// subjects are numeric user ids, large ones are decoded from json as 1e+06 unless handled as json.Number.
+var subjects = []uint64{1, 1000000, 1 << 60}
+
+func TestAuth_ListSessions(t *testing.T) {
+	for _, sub := range subjects {
+		t.Run(fmt.Sprint(sub), func(t *testing.T) {
+			auth, _ := newAuth(t, sub)
+			first := mustLogin(t, auth, "google")
+			mustLogin(t, auth, "google")
+
+			// refresh stays within the same session
+			if _, err := auth.RefreshTokenHandler(context.Background(), first.RefreshToken); err != nil {
+				t.Fatal(err)
+			}
+
+			sessions, err := auth.ListSessions(context.Background(), sub)
+			if err != nil {
+				t.Fatalf("ListSessions() error = %v", err)
+			}
+			if len(sessions) != 2 {
+				t.Fatalf("ListSessions() = %d sessions, want 2", len(sessions))
+			}
+
+			other, err := auth.ListSessions(context.Background(), sub+1)
+			if err != nil || len(other) != 0 {
+				t.Fatalf("ListSessions() of other user = %v, %v, want no sessions", other, err)
+			}
+		})
+	}
+}
+
+func TestAuth_RevokeSession(t *testing.T) {
+	for _, sub := range subjects {
+		t.Run(fmt.Sprint(sub), func(t *testing.T) {
+			auth, _ := newAuth(t, sub)
+			td := mustLogin(t, auth, "google")
+			kept := mustLogin(t, auth, "google")
+
+			sessions, err := auth.ListSessions(context.Background(), sub)
+			if err != nil {
+				t.Fatal(err)
+			}
+			revoked := sessions[0]
+
+			if err = auth.RevokeSession(context.Background(), sub+1, revoked.ID); !errors.Is(err, hamr.ErrSessionNotFound) {
+				t.Fatalf("RevokeSession() of other user error = %v, want hamr.ErrSessionNotFound", err)
+			}
+
+			if err = auth.RevokeSession(context.Background(), sub, revoked.ID); err != nil {
+				t.Fatalf("RevokeSession() error = %v", err)
+			}
+
+			if err = auth.Authorized(authorizedRequest(td.AccessToken)); err == nil {
+				t.Fatal("Authorized() with revoked session error = nil, want error")
+			}
+
+			if err = auth.Authorized(authorizedRequest(kept.AccessToken)); err != nil {
+				t.Fatalf("Authorized() with other session error = %v", err)
+			}
+
+			sessions, err = auth.ListSessions(context.Background(), sub)
+			if err != nil || len(sessions) != 1 {
+				t.Fatalf("ListSessions() = %v, %v, want 1 session", sessions, err)
+			}
+
+			if err = auth.RevokeSession(context.Background(), sub, revoked.ID); !errors.Is(err, hamr.ErrSessionNotFound) {
+				t.Fatalf("RevokeSession() of revoked session error = %v, want hamr.ErrSessionNotFound", err)
+			}
+		})
+	}
+}
+
+func TestAuth_RevokeAllSessions(t *testing.T) {
+	for _, sub := range subjects {
+		t.Run(fmt.Sprint(sub), func(t *testing.T) {
+			auth, _ := newAuth(t, sub)
+			tokens := []hamr.TokenDetails{
+				mustLogin(t, auth, "google"),
+				mustLogin(t, auth, "google"),
+			}
+
+			if err := auth.RevokeAllSessions(context.Background(), sub); err != nil {
+				t.Fatalf("RevokeAllSessions() error = %v", err)
+			}
+
+			for _, td := range tokens {
+				if err := auth.Authorized(authorizedRequest(td.AccessToken)); err == nil {
+					t.Fatal("Authorized() after RevokeAllSessions() error = nil, want error")
+				}
+
+				if _, err := auth.RefreshTokenHandler(context.Background(), td.RefreshToken); err == nil {
+					t.Fatal("RefreshTokenHandler() after RevokeAllSessions() error = nil, want error")
+				}
+			}
+
+			sessions, err := auth.ListSessions(context.Background(), sub)
+			if err != nil || len(sessions) != 0 {
+				t.Fatalf("ListSessions() = %v, %v, want no sessions", sessions, err)
+			}
+		})
+	}
+}
+
+func TestAuth_RevokeAllSessions_TieredStorage(t *testing.T) {
+	backend := memory.New()
+	t.Cleanup(backend.Close)
+	conf := hamr.NewConfig()
+
+	// two instances with long-lived local caches over shared backend, without invalidation
+	var instances []*hamr.Auth[uint]
+	for i := 0; i < 2; i++ {
+		storage, err := tiered.New(backend, tiered.WithLocalTTL(time.Hour), tiered.WithNegativeTTL(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(storage.Close)
+
+		instances = append(instances, newAuthWithStorage[uint](t, storage, conf, 1))
+	}
+	a, b := instances[0], instances[1]
+
+	tokens := []hamr.TokenDetails{
+		mustLogin(t, a, "google"),
+		mustLogin(t, b, "google"),
+		mustLogin(t, a, "google"),
+	}
+
+	sessions, err := b.ListSessions(context.Background(), 1)
+	if err != nil || len(sessions) != 3 {
+		t.Fatalf("ListSessions() = %v, %v, want sessions from both instances", sessions, err)
+	}
+
+	if err = a.RevokeAllSessions(context.Background(), 1); err != nil {
+		t.Fatalf("RevokeAllSessions() error = %v", err)
+	}
+
+	for i, td := range tokens {
+		if err = a.Authorized(authorizedRequest(td.AccessToken)); !errors.Is(err, hamr.ErrTokenRevoked) {
+			t.Errorf("Authorized() with session %d after RevokeAllSessions() error = %v, want hamr.ErrTokenRevoked", i, err)
+		}
+	}
+}
+
+func TestAuth_SessionMetadata(t *testing.T) {
+	tests := map[string]struct {
+		trustedProxies []string
+		remoteAddr     string
+		forwardedFor   string
+		wantIP         string
+	}{
+		"direct client": {
+			remoteAddr:   "203.0.113.7:4321",
+			forwardedFor: "198.51.100.1",
+			wantIP:       "203.0.113.7",
+		},
+		"trusted proxy": {
+			trustedProxies: []string{"10.0.0.0/8"},
+			remoteAddr:     "10.0.0.2:4321",
+			forwardedFor:   "198.51.100.1, 10.0.0.1",
+			wantIP:         "198.51.100.1",
+		},
+		"spoofed forwarded for": {
+			trustedProxies: []string{"10.0.0.2"},
+			remoteAddr:     "10.0.0.2:4321",
+			forwardedFor:   "192.0.2.99, 198.51.100.1",
+			wantIP:         "198.51.100.1",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			auth, conf := newAuth[uint](t, 1)
+			conf.TrustedProxies = tt.trustedProxies
+
+			r := callbackRequest(t, "/", func(w http.ResponseWriter, r *http.Request) error {
+				return auth.OAauthLoginHandler("google", w, r)
+			})
+			r.RemoteAddr = tt.remoteAddr
+			r.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			r.Header.Set("User-Agent", "test-agent")
+
+			if _, err := auth.OAuthLoginCallbackHandler(context.Background(), "google", r); err != nil {
+				t.Fatal(err)
+			}
+
+			sessions, err := auth.ListSessions(context.Background(), 1)
+			if err != nil || len(sessions) != 1 {
+				t.Fatalf("ListSessions() = %v, %v, want 1 session", sessions, err)
+			}
+
+			session := sessions[0]
+			if session.IP != tt.wantIP {
+				t.Errorf("session IP = %s, want %s", session.IP, tt.wantIP)
+			}
+			if session.UserAgent != "test-agent" {
+				t.Errorf("session user agent = %s, want test-agent", session.UserAgent)
+			}
+			if session.Provider != "google" {
+				t.Errorf("session provider = %s, want google", session.Provider)
+			}
+			if session.CreatedAt.IsZero() || !session.ExpiresAt.After(session.CreatedAt) {
+				t.Errorf("session created at %v, expires at %v", session.CreatedAt, session.ExpiresAt)
+			}
+		})
+	}
+}
+
+func TestAuth_MaxSessions_RejectNewSession(t *testing.T) {
+	for _, sub := range subjects {
+		t.Run(fmt.Sprint(sub), func(t *testing.T) {
+			auth, conf := newAuth(t, sub)
+			conf.MaxSessions = 2
+
+			mustLogin(t, auth, "google")
+			td := mustLogin(t, auth, "google")
+
+			_, err := login(t, auth, "google")
+			var limitErr *hamr.SessionLimitError
+			if !errors.As(err, &limitErr) || limitErr.Limit != 2 {
+				t.Fatalf("login() over limit error = %v, want *hamr.SessionLimitError", err)
+			}
+
+			// existing sessions are not affected
+			if err = auth.Authorized(authorizedRequest(td.AccessToken)); err != nil {
+				t.Fatalf("Authorized() error = %v", err)
+			}
+
+			// refresh does not count as a new session
+			if _, err = auth.RefreshTokenHandler(context.Background(), td.RefreshToken); err != nil {
+				t.Fatalf("RefreshTokenHandler() at limit error = %v", err)
+			}
+		})
+	}
+}
+
+func TestAuth_MaxSessions_EvictOldestSession(t *testing.T) {
+	for _, sub := range subjects {
+		t.Run(fmt.Sprint(sub), func(t *testing.T) {
+			auth, conf := newAuth(t, sub)
+			conf.MaxSessions = 2
+			conf.SessionLimitPolicy = hamr.EvictOldestSession
+
+			oldest := mustLogin(t, auth, "google")
+			second := mustLogin(t, auth, "google")
+			newest := mustLogin(t, auth, "google")
+
+			if err := auth.Authorized(authorizedRequest(oldest.AccessToken)); err == nil {
+				t.Fatal("Authorized() with evicted session error = nil, want error")
+			}
+
+			for _, td := range []hamr.TokenDetails{second, newest} {
+				if err := auth.Authorized(authorizedRequest(td.AccessToken)); err != nil {
+					t.Fatalf("Authorized() error = %v", err)
+				}
+			}
+
+			sessions, err := auth.ListSessions(context.Background(), sub)
+			if err != nil || len(sessions) != 2 {
+				t.Fatalf("ListSessions() = %v, %v, want 2 sessions", sessions, err)
+			}
+		})
+	}
+}
+
+func TestAuth_IdleTimeout(t *testing.T) {
+	auth, conf := newAuth[uint](t, 1)
+	conf.IdleTimeout = time.Millisecond * 200
+	td := mustLogin(t, auth, "google")
+
+	// each authorized request extends idle deadline, session outlives IdleTimeout while in use
+	for i := 0; i < 3; i++ {
+		time.Sleep(time.Millisecond * 100)
+		if err := auth.Authorized(authorizedRequest(td.AccessToken)); err != nil {
+			t.Fatalf("Authorized() of active session error = %v", err)
+		}
+	}
+
+	time.Sleep(time.Millisecond * 300)
+
+	if err := auth.Authorized(authorizedRequest(td.AccessToken)); !errors.Is(err, hamr.ErrSessionExpired) {
+		t.Fatalf("Authorized() of idle session error = %v, want hamr.ErrSessionExpired", err)
+	}
+
+	if _, err := auth.RefreshTokenHandler(context.Background(), td.RefreshToken); err == nil {
+		t.Fatal("RefreshTokenHandler() of expired session error = nil, want error")
+	}
+}
+
+func TestAuth_AbsoluteSessionLifetime(t *testing.T) {
+	auth, conf := newAuth[uint](t, 1)
+	conf.AbsoluteSessionLifetime = time.Millisecond * 100
+	td := mustLogin(t, auth, "google")
+
+	if err := auth.Authorized(authorizedRequest(td.AccessToken)); err != nil {
+		t.Fatalf("Authorized() error = %v", err)
+	}
+
+	time.Sleep(time.Millisecond * 150)
+
+	if _, err := auth.RefreshTokenHandler(context.Background(), td.RefreshToken); !errors.Is(err, hamr.ErrSessionExpired) {
+		t.Fatalf("RefreshTokenHandler() after lifetime error = %v, want hamr.ErrSessionExpired", err)
+	}
+
+	sessions, err := auth.ListSessions(context.Background(), 1)
+	if err != nil || len(sessions) != 0 {
+		t.Fatalf("ListSessions() = %v, %v, want expired session revoked", sessions, err)
+	}
+}
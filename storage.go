@@ -0,0 +1,46 @@
+package hamr
+
+import (
+	"context"
+)
+
+// contextStorage adapts TokenStorage without context support to ContextTokenStorage. Context is ignored.
+type contextStorage struct {
+	TokenStorage
+}
+
+// StorageWithContext will adapt TokenStorage to ContextTokenStorage.
+// Storage which already implements ContextTokenStorage is used as is, otherwise context is ignored.
+func StorageWithContext(storage TokenStorage) ContextTokenStorage {
+	if ctxStorage, ok := storage.(ContextTokenStorage); ok {
+		return ctxStorage
+	}
+
+	return &contextStorage{TokenStorage: storage}
+}
+
+func (s *contextStorage) StoreContext(_ context.Context, item ...*Item) error {
+	return s.Store(item...)
+}
+
+func (s *contextStorage) LoadContext(_ context.Context, key string) ([]byte, error) {
+	return s.Load(key)
+}
+
+func (s *contextStorage) DeleteContext(_ context.Context, key ...string) error {
+	return s.Delete(key...)
+}
+
+type noCacheKey struct{}
+
+// WithNoCache marks storage calls made with ctx as reads of the latest value, caching storages (ex. storage/tiered)
+// must load keys from their backend instead of local cache. Used to read indexes which are updated by read-modify-write.
+func WithNoCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCacheKey{}, true)
+}
+
+// NoCache reports whether ctx is marked with WithNoCache.
+func NoCache(ctx context.Context) bool {
+	noCache, _ := ctx.Value(noCacheKey{}).(bool)
+	return noCache
+}
@@ -0,0 +1,228 @@
+package bolt
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	bbolt "go.etcd.io/bbolt"
+
+	"github.com/semirm-dev/hamr"
+)
+
+const (
+	defaultBucket        = "hamr"
+	defaultPurgeInterval = time.Minute * 5
+	defaultOpenTimeout   = time.Second * 5
+	// expiresAtLen is the length of expiry prefix of each stored value (unix nanoseconds)
+	expiresAtLen = 8
+)
+
+// Storage is hamr.TokenStorage implementation backed by embedded bbolt file, for single-node deployments.
+// Items survive restarts, expired items are removed periodically in background.
+type Storage struct {
+	db            *bbolt.DB
+	bucket        []byte
+	purgeInterval time.Duration
+	openTimeout   time.Duration
+	stop          chan struct{}
+	stopOnce      sync.Once
+	wg            sync.WaitGroup
+}
+
+type Option func(*Storage)
+
+// New will open (or create) bbolt file at given path and start removing expired items. Call Close to release the file.
+// File is locked, so it can be used only by a single process.
+func New(path string, opts ...Option) (*Storage, error) {
+	s := &Storage{
+		bucket:        []byte(defaultBucket),
+		purgeInterval: defaultPurgeInterval,
+		openTimeout:   defaultOpenTimeout,
+		stop:          make(chan struct{}),
+	}
+
+	for _, o := range opts {
+		o(s)
+	}
+
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: s.openTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+
+	if err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(s.bucket)
+		return err
+	}); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	s.db = db
+
+	if s.purgeInterval > 0 {
+		s.wg.Add(1)
+		go s.purge()
+	}
+
+	return s, nil
+}
+
+// WithBucket will store items in given bucket instead of hamr.
+func WithBucket(bucket string) Option {
+	return func(s *Storage) {
+		s.bucket = []byte(bucket)
+	}
+}
+
+// WithPurgeInterval defines how often expired items are removed. 0 disables purging.
+func WithPurgeInterval(interval time.Duration) Option {
+	return func(s *Storage) {
+		s.purgeInterval = interval
+	}
+}
+
+// WithOpenTimeout defines how long to wait for file lock held by another process.
+func WithOpenTimeout(timeout time.Duration) Option {
+	return func(s *Storage) {
+		s.openTimeout = timeout
+	}
+}
+
+func (s *Storage) Store(items ...*hamr.Item) error {
+	values := make([][]byte, len(items))
+	for i, item := range items {
+		itemBytes, err := json.Marshal(item.Value)
+		if err != nil {
+			return err
+		}
+
+		var expiresAt int64
+		if item.Expiration > 0 {
+			expiresAt = time.Now().Add(item.Expiration).UnixNano()
+		}
+
+		values[i] = encode(expiresAt, itemBytes)
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		for i, item := range items {
+			if err := b.Put([]byte(item.Key), values[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Storage) Load(key string) ([]byte, error) {
+	var value []byte
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		stored := tx.Bucket(s.bucket).Get([]byte(key))
+		if stored == nil {
+			return fmt.Errorf("%w: %v", hamr.ErrNotFound, key)
+		}
+
+		expiresAt, itemBytes := decode(stored)
+		if expired(expiresAt, time.Now()) {
+			return fmt.Errorf("%w: %v", hamr.ErrNotFound, key)
+		}
+
+		// stored bytes are valid only during transaction
+		value = append([]byte(nil), itemBytes...)
+		return nil
+	})
+
+	return value, err
+}
+
+func (s *Storage) Delete(keys ...string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		for _, key := range keys {
+			if err := b.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Close will stop removing expired items and close bbolt file.
+func (s *Storage) Close() error {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+	s.wg.Wait()
+
+	return s.db.Close()
+}
+
+// purge will periodically remove expired items until storage is closed.
+// Freed pages are reused by bbolt, file is not shrunk.
+func (s *Storage) purge() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			_ = s.deleteExpired()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *Storage) deleteExpired() error {
+	now := time.Now()
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+
+		// deleting while iterating with cursor skips items, so expired keys are collected first
+		var expiredKeys [][]byte
+		if err := b.ForEach(func(k, v []byte) error {
+			if expiresAt, _ := decode(v); expired(expiresAt, now) {
+				expiredKeys = append(expiredKeys, append([]byte(nil), k...))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, k := range expiredKeys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func encode(expiresAt int64, itemBytes []byte) []byte {
+	value := make([]byte, expiresAtLen+len(itemBytes))
+	binary.BigEndian.PutUint64(value, uint64(expiresAt))
+	copy(value[expiresAtLen:], itemBytes)
+
+	return value
+}
+
+func decode(value []byte) (int64, []byte) {
+	if len(value) < expiresAtLen {
+		return 0, value
+	}
+
+	return int64(binary.BigEndian.Uint64(value[:expiresAtLen])), value[expiresAtLen:]
+}
+
+func expired(expiresAt int64, now time.Time) bool {
+	return expiresAt > 0 && now.UnixNano() > expiresAt
+}
@@ -0,0 +1,26 @@
+package bolt_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/semirm-dev/hamr"
+	"github.com/semirm-dev/hamr/storage/bolt"
+	"github.com/semirm-dev/hamr/storage/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) hamr.TokenStorage {
+		s, err := bolt.New(filepath.Join(t.TempDir(), "hamr.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			if err = s.Close(); err != nil {
+				t.Error(err)
+			}
+		})
+
+		return s
+	})
+}
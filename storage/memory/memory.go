@@ -0,0 +1,193 @@
+package memory
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/semirm-dev/hamr"
+)
+
+// defaultCleanupInterval defines how often expired items are removed by janitor
+const defaultCleanupInterval = time.Minute
+
+// Storage is concurrency-safe in-memory hamr.TokenStorage implementation.
+// Items are removed once they expire. If max entries limit is set, least recently used items are evicted.
+type Storage struct {
+	mu              sync.Mutex
+	items           map[string]*list.Element
+	lru             *list.List
+	maxEntries      int
+	cleanupInterval time.Duration
+	stop            chan struct{}
+	stopOnce        sync.Once
+}
+
+type entry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+type Option func(*Storage)
+
+// New will create in-memory storage and start its janitor. Call Close to stop the janitor.
+func New(opts ...Option) *Storage {
+	s := &Storage{
+		items:           make(map[string]*list.Element),
+		lru:             list.New(),
+		cleanupInterval: defaultCleanupInterval,
+		stop:            make(chan struct{}),
+	}
+
+	for _, o := range opts {
+		o(s)
+	}
+
+	if s.cleanupInterval > 0 {
+		go s.janitor()
+	}
+
+	return s
+}
+
+// WithMaxEntries will limit number of stored items, least recently used items are evicted. 0 means unlimited.
+func WithMaxEntries(maxEntries int) Option {
+	return func(s *Storage) {
+		s.maxEntries = maxEntries
+	}
+}
+
+// WithCleanupInterval defines how often janitor removes expired items. 0 disables the janitor.
+func WithCleanupInterval(interval time.Duration) Option {
+	return func(s *Storage) {
+		s.cleanupInterval = interval
+	}
+}
+
+func (s *Storage) Store(items ...*hamr.Item) error {
+	values := make([][]byte, len(items))
+	for i, item := range items {
+		itemBytes, err := json.Marshal(item.Value)
+		if err != nil {
+			return err
+		}
+		values[i] = itemBytes
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, item := range items {
+		var expiresAt time.Time
+		if item.Expiration > 0 {
+			expiresAt = time.Now().Add(item.Expiration)
+		}
+
+		if el, ok := s.items[item.Key]; ok {
+			e := el.Value.(*entry)
+			e.value = values[i]
+			e.expiresAt = expiresAt
+			s.lru.MoveToFront(el)
+			continue
+		}
+
+		s.items[item.Key] = s.lru.PushFront(&entry{
+			key:       item.Key,
+			value:     values[i],
+			expiresAt: expiresAt,
+		})
+	}
+
+	for s.maxEntries > 0 && s.lru.Len() > s.maxEntries {
+		s.removeElement(s.lru.Back())
+	}
+
+	return nil
+}
+
+func (s *Storage) Load(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.items[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", hamr.ErrNotFound, key)
+	}
+
+	e := el.Value.(*entry)
+	if e.expired(time.Now()) {
+		s.removeElement(el)
+		return nil, fmt.Errorf("%w: %v", hamr.ErrNotFound, key)
+	}
+
+	s.lru.MoveToFront(el)
+
+	return e.value, nil
+}
+
+func (s *Storage) Delete(keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		if el, ok := s.items[key]; ok {
+			s.removeElement(el)
+		}
+	}
+
+	return nil
+}
+
+// Len returns number of stored items, including expired items not yet removed by janitor.
+func (s *Storage) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lru.Len()
+}
+
+// Close will stop the janitor. Storage can still be used, expired items are then removed only on Load.
+func (s *Storage) Close() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
+// janitor will periodically remove expired items until storage is closed.
+func (s *Storage) janitor() {
+	ticker := time.NewTicker(s.cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.deleteExpired()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *Storage) deleteExpired() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, el := range s.items {
+		if el.Value.(*entry).expired(now) {
+			s.removeElement(el)
+		}
+	}
+}
+
+func (s *Storage) removeElement(el *list.Element) {
+	s.lru.Remove(el)
+	delete(s.items, el.Value.(*entry).key)
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && now.After(e.expiresAt)
+}
@@ -0,0 +1,43 @@
+package memory_test
+
+import (
+	"testing"
+
+	"github.com/semirm-dev/hamr"
+	"github.com/semirm-dev/hamr/storage/memory"
+	"github.com/semirm-dev/hamr/storage/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) hamr.TokenStorage {
+		s := memory.New()
+		t.Cleanup(s.Close)
+
+		return s
+	})
+}
+
+func TestStorage_MaxEntries(t *testing.T) {
+	s := memory.New(memory.WithMaxEntries(2))
+	defer s.Close()
+
+	if err := s.Store(&hamr.Item{Key: "a", Value: "a"}, &hamr.Item{Key: "b", Value: "b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a is now the most recently used
+	if _, err := s.Load("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Store(&hamr.Item{Key: "c", Value: "c"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Load("b"); err == nil {
+		t.Fatal("least recently used item b should be evicted")
+	}
+	if s.Len() != 2 {
+		t.Fatalf("Len() = %d, want 2", s.Len())
+	}
+}
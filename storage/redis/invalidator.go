@@ -0,0 +1,56 @@
+package redis
+
+import (
+	"context"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// Invalidator publishes cache invalidation messages through redis pub/sub.
+// It can be used with tiered storage to invalidate local caches of all instances.
+type Invalidator struct {
+	client  goredis.UniversalClient
+	channel string
+}
+
+func NewInvalidator(client goredis.UniversalClient, channel string) *Invalidator {
+	return &Invalidator{
+		client:  client,
+		channel: channel,
+	}
+}
+
+func (i *Invalidator) Publish(ctx context.Context, message []byte) error {
+	return i.client.Publish(ctx, i.channel, message).Err()
+}
+
+// Subscribe will call handler for each message until ctx is done. It returns once subscription is confirmed by redis.
+func (i *Invalidator) Subscribe(ctx context.Context, handler func(message []byte)) error {
+	pubSub := i.client.Subscribe(ctx, i.channel)
+
+	if _, err := pubSub.Receive(ctx); err != nil {
+		_ = pubSub.Close()
+		return err
+	}
+
+	go func() {
+		defer func() {
+			_ = pubSub.Close()
+		}()
+
+		messages := pubSub.Channel()
+		for {
+			select {
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				handler([]byte(msg.Payload))
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
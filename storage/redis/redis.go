@@ -0,0 +1,138 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	goredis "github.com/redis/go-redis/v9"
+
+	"github.com/semirm-dev/hamr"
+)
+
+// Storage is hamr.TokenStorage implementation backed by redis.
+// Single node, cluster and sentinel setups are supported through redis.UniversalClient.
+type Storage struct {
+	client goredis.UniversalClient
+	prefix string
+}
+
+// Config for redis connection.
+// Multiple Addrs will connect to redis cluster, MasterName will connect to redis sentinel.
+type Config struct {
+	Addrs      []string
+	MasterName string
+	Username   string
+	Password   string
+	DB         int
+	// KeyPrefix is prepended to all keys, so multiple applications can share the same redis.
+	KeyPrefix string
+}
+
+func NewConfig() *Config {
+	return &Config{
+		Addrs: []string{"localhost:6379"},
+	}
+}
+
+// New will connect to redis and verify connection with ping.
+func New(ctx context.Context, conf *Config) (*Storage, error) {
+	client := goredis.NewUniversalClient(&goredis.UniversalOptions{
+		Addrs:      conf.Addrs,
+		MasterName: conf.MasterName,
+		Username:   conf.Username,
+		Password:   conf.Password,
+		DB:         conf.DB,
+	})
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+
+	return NewWithClient(client, conf.KeyPrefix), nil
+}
+
+// NewWithClient will use already configured redis client.
+func NewWithClient(client goredis.UniversalClient, keyPrefix string) *Storage {
+	return &Storage{
+		client: client,
+		prefix: keyPrefix,
+	}
+}
+
+func (s *Storage) Store(items ...*hamr.Item) error {
+	return s.StoreContext(context.Background(), items...)
+}
+
+func (s *Storage) Load(key string) ([]byte, error) {
+	return s.LoadContext(context.Background(), key)
+}
+
+func (s *Storage) Delete(keys ...string) error {
+	return s.DeleteContext(context.Background(), keys...)
+}
+
+// StoreContext will save all items in a single pipeline.
+func (s *Storage) StoreContext(ctx context.Context, items ...*hamr.Item) error {
+	values := make([][]byte, len(items))
+	for i, item := range items {
+		itemBytes, err := json.Marshal(item.Value)
+		if err != nil {
+			return err
+		}
+		values[i] = itemBytes
+	}
+
+	_, err := s.client.Pipelined(ctx, func(pipe goredis.Pipeliner) error {
+		for i, item := range items {
+			pipe.Set(ctx, s.key(item.Key), values[i], item.Expiration)
+		}
+		return nil
+	})
+
+	return hamr.Transient(err)
+}
+
+func (s *Storage) LoadContext(ctx context.Context, key string) ([]byte, error) {
+	cacheValue, err := s.client.Get(ctx, s.key(key)).Bytes()
+
+	switch {
+	// key does not exist
+	case errors.Is(err, goredis.Nil):
+		return nil, fmt.Errorf("%w: %v", hamr.ErrNotFound, key)
+	// some other error
+	case err != nil:
+		return nil, hamr.Transient(err)
+	}
+
+	return cacheValue, nil
+}
+
+// DeleteContext will delete all keys in a single pipeline.
+// Keys are deleted one by one, so it works with redis cluster where keys can live in different slots.
+func (s *Storage) DeleteContext(ctx context.Context, keys ...string) error {
+	_, err := s.client.Pipelined(ctx, func(pipe goredis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Del(ctx, s.key(key))
+		}
+		return nil
+	})
+
+	return hamr.Transient(err)
+}
+
+// Client returns underlying redis client, ex. to share the connection with Invalidator.
+func (s *Storage) Client() goredis.UniversalClient {
+	return s.client
+}
+
+// Close will close redis connection.
+func (s *Storage) Close() error {
+	return s.client.Close()
+}
+
+func (s *Storage) key(key string) string {
+	return s.prefix + key
+}
@@ -0,0 +1,30 @@
+package redis_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/redis/go-redis/v9"
+
+	"github.com/semirm-dev/hamr"
+	"github.com/semirm-dev/hamr/storage/redis"
+	"github.com/semirm-dev/hamr/storage/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	m := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: m.Addr()})
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+
+	storagetest.Run(t, func(t *testing.T) hamr.TokenStorage {
+		m.FlushAll()
+
+		return redis.NewWithClient(client, "test:")
+	}, storagetest.WithSleep(func(d time.Duration) {
+		// miniredis does not expire keys on its own
+		m.FastForward(d)
+	}))
+}
@@ -0,0 +1,209 @@
+package sql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/semirm-dev/hamr"
+)
+
+const (
+	defaultTableName     = "hamr_sessions"
+	defaultPurgeInterval = time.Minute * 5
+)
+
+// Storage is hamr.TokenStorage implementation backed by sql database through gorm (postgres, mysql, sqlserver...).
+// Expired rows are purged periodically in background.
+type Storage struct {
+	db            *gorm.DB
+	tableName     string
+	purgeInterval time.Duration
+	stop          chan struct{}
+	stopOnce      sync.Once
+}
+
+// entry is a single stored item. Subject is taken from item's sub value, if there is one.
+type entry struct {
+	Key       string     `gorm:"column:item_key;primaryKey;size:255"`
+	Subject   string     `gorm:"index;size:255"`
+	Value     []byte     `gorm:"not null"`
+	ExpiresAt *time.Time `gorm:"index"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type Option func(*Storage)
+
+// New will auto-migrate sessions table and start purging expired rows. Call Close to stop purging.
+func New(db *gorm.DB, opts ...Option) (*Storage, error) {
+	s := &Storage{
+		db:            db,
+		tableName:     defaultTableName,
+		purgeInterval: defaultPurgeInterval,
+		stop:          make(chan struct{}),
+	}
+
+	for _, o := range opts {
+		o(s)
+	}
+
+	if err := s.table(context.Background()).AutoMigrate(&entry{}); err != nil {
+		return nil, fmt.Errorf("failed to migrate %s table: %w", s.tableName, err)
+	}
+
+	if s.purgeInterval > 0 {
+		go s.purge()
+	}
+
+	return s, nil
+}
+
+// WithTableName will store items in given table instead of hamr_sessions.
+func WithTableName(tableName string) Option {
+	return func(s *Storage) {
+		s.tableName = tableName
+	}
+}
+
+// WithPurgeInterval defines how often expired rows are deleted. 0 disables purging.
+func WithPurgeInterval(interval time.Duration) Option {
+	return func(s *Storage) {
+		s.purgeInterval = interval
+	}
+}
+
+func (s *Storage) Store(items ...*hamr.Item) error {
+	return s.StoreContext(context.Background(), items...)
+}
+
+func (s *Storage) Load(key string) ([]byte, error) {
+	return s.LoadContext(context.Background(), key)
+}
+
+func (s *Storage) Delete(keys ...string) error {
+	return s.DeleteContext(context.Background(), keys...)
+}
+
+// StoreContext will insert or update all items in a single statement.
+func (s *Storage) StoreContext(ctx context.Context, items ...*hamr.Item) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	entries := make([]*entry, len(items))
+	for i, item := range items {
+		itemBytes, err := json.Marshal(item.Value)
+		if err != nil {
+			return err
+		}
+
+		var expiresAt *time.Time
+		if item.Expiration > 0 {
+			t := now.Add(item.Expiration)
+			expiresAt = &t
+		}
+
+		entries[i] = &entry{
+			Key:       item.Key,
+			Subject:   subject(itemBytes),
+			Value:     itemBytes,
+			ExpiresAt: expiresAt,
+		}
+	}
+
+	return hamr.Transient(s.table(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "item_key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"subject", "value", "expires_at", "updated_at"}),
+	}).Create(&entries).Error)
+}
+
+func (s *Storage) LoadContext(ctx context.Context, key string) ([]byte, error) {
+	var entries []*entry
+	if err := s.active(ctx).Where("item_key = ?", key).Limit(1).Find(&entries).Error; err != nil {
+		return nil, hamr.Transient(err)
+	}
+
+	// key does not exist
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%w: %v", hamr.ErrNotFound, key)
+	}
+
+	return entries[0].Value, nil
+}
+
+func (s *Storage) DeleteContext(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	return hamr.Transient(s.table(ctx).Where("item_key IN ?", keys).Delete(&entry{}).Error)
+}
+
+// KeysBySubject will return keys of all active items stored for given subject (user).
+func (s *Storage) KeysBySubject(ctx context.Context, sub string) ([]string, error) {
+	var keys []string
+	err := s.active(ctx).Where("subject = ?", sub).Pluck("item_key", &keys).Error
+
+	return keys, hamr.Transient(err)
+}
+
+// Close will stop purging expired rows.
+func (s *Storage) Close() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
+// purge will periodically delete expired rows until storage is closed.
+func (s *Storage) purge() {
+	ticker := time.NewTicker(s.purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.deleteExpired(context.Background()); err != nil {
+				s.db.Logger.Error(context.Background(), "failed to purge expired %s: %s", s.tableName, err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *Storage) deleteExpired(ctx context.Context) error {
+	return s.table(ctx).Where("expires_at <= ?", time.Now().UTC()).Delete(&entry{}).Error
+}
+
+func (s *Storage) table(ctx context.Context) *gorm.DB {
+	return s.db.WithContext(ctx).Table(s.tableName)
+}
+
+// active will filter out expired rows which are not purged yet.
+func (s *Storage) active(ctx context.Context) *gorm.DB {
+	return s.table(ctx).Where("expires_at IS NULL OR expires_at > ?", time.Now().UTC())
+}
+
+// subject will get sub from stored json value, so items can be looked up by user.
+// Numbers are kept as written (json.Number), so sub 1000000 is stored as "1000000", not "1e+06".
+func subject(value []byte) string {
+	var v struct {
+		Sub interface{} `json:"sub"`
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil || v.Sub == nil {
+		return ""
+	}
+
+	return fmt.Sprint(v.Sub)
+}
@@ -0,0 +1,63 @@
+package sql_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/semirm-dev/hamr"
+	"github.com/semirm-dev/hamr/storage/sql"
+	"github.com/semirm-dev/hamr/storage/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) hamr.TokenStorage {
+		return newStorage(t)
+	})
+}
+
+func TestStorage_KeysBySubject(t *testing.T) {
+	s := newStorage(t)
+	ctx := context.Background()
+
+	if err := s.StoreContext(ctx,
+		&hamr.Item{Key: "a", Value: hamr.TokenClaims{"sub": 1000000}, Expiration: time.Minute},
+		&hamr.Item{Key: "b", Value: hamr.TokenClaims{"sub": uint64(1) << 60}, Expiration: time.Minute},
+		&hamr.Item{Key: "c", Value: hamr.TokenClaims{"sub": "user-1"}, Expiration: time.Minute},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	for sub, want := range map[string]string{"1000000": "a", "1152921504606846976": "b", "user-1": "c"} {
+		keys, err := s.KeysBySubject(ctx, sub)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(keys) != 1 || keys[0] != want {
+			t.Errorf("KeysBySubject(%s) = %v, want [%s]", sub, keys, want)
+		}
+	}
+}
+
+func newStorage(t *testing.T) *sql.Storage {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "hamr.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := sql.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+
+	return s
+}
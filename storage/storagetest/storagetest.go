@@ -0,0 +1,291 @@
+// Package storagetest is a conformance test suite for hamr.TokenStorage implementations.
+//
+// Storage implementations should run it from their tests:
+//
+//	func TestStorage(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T) hamr.TokenStorage {
+//			return mystorage.New()
+//		})
+//	}
+package storagetest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/semirm-dev/hamr"
+)
+
+// ttl is the expiration used in expiry tests, long enough for storages with millisecond precision
+const ttl = time.Millisecond * 200
+
+// Factory creates a new, empty storage for each test.
+type Factory func(t *testing.T) hamr.TokenStorage
+
+type suite struct {
+	newStorage Factory
+	sleep      func(time.Duration)
+}
+
+type Option func(*suite)
+
+// WithSleep replaces time.Sleep used in expiry tests.
+// Storages with simulated clock (ex. miniredis) can fast-forward their time instead.
+func WithSleep(sleep func(time.Duration)) Option {
+	return func(s *suite) {
+		s.sleep = sleep
+	}
+}
+
+// Run will verify that storage behaves the way hamr.Auth expects:
+// json round-tripping of Item.Value, multi-key Store and Delete, ErrNotFound for missing keys, expiration and concurrent use.
+func Run(t *testing.T, newStorage Factory, opts ...Option) {
+	s := &suite{
+		newStorage: newStorage,
+		sleep:      time.Sleep,
+	}
+
+	for _, o := range opts {
+		o(s)
+	}
+
+	t.Run("StoreAndLoad", s.testStoreAndLoad)
+	t.Run("StoreMultiple", s.testStoreMultiple)
+	t.Run("StoreNothing", s.testStoreNothing)
+	t.Run("Overwrite", s.testOverwrite)
+	t.Run("LoadMissing", s.testLoadMissing)
+	t.Run("Delete", s.testDelete)
+	t.Run("DeleteMissing", s.testDeleteMissing)
+	t.Run("Expiration", s.testExpiration)
+	t.Run("OverwriteExpiration", s.testOverwriteExpiration)
+	t.Run("Concurrency", s.testConcurrency)
+}
+
+func (s *suite) testStoreAndLoad(t *testing.T) {
+	storage := s.newStorage(t)
+
+	values := map[string]interface{}{
+		"token":        hamr.TokenClaims{"sub": 1, "refresh_token_uuid": "uuid-1", "family_id": "family-1"},
+		"family:abc":   struct{ ID, Provider string }{ID: "abc", Provider: "google"},
+		"sessions:1":   []string{"abc", "def"},
+		"idle:abc":     time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC),
+		"string":       "value",
+		"number":       42.5,
+		"unicode/ключ": "значение",
+	}
+
+	for key, value := range values {
+		mustStore(t, storage, &hamr.Item{Key: key, Value: value, Expiration: time.Minute})
+	}
+
+	for key, value := range values {
+		assertValue(t, storage, key, value)
+	}
+}
+
+func (s *suite) testStoreMultiple(t *testing.T) {
+	storage := s.newStorage(t)
+
+	var items []*hamr.Item
+	for i := 0; i < 10; i++ {
+		items = append(items, &hamr.Item{
+			Key:        fmt.Sprintf("key-%d", i),
+			Value:      hamr.TokenClaims{"i": i},
+			Expiration: time.Minute,
+		})
+	}
+
+	mustStore(t, storage, items...)
+
+	for _, item := range items {
+		assertValue(t, storage, item.Key, item.Value)
+	}
+}
+
+func (s *suite) testStoreNothing(t *testing.T) {
+	storage := s.newStorage(t)
+
+	if err := storage.Store(); err != nil {
+		t.Fatalf("Store() without items: %v", err)
+	}
+}
+
+func (s *suite) testOverwrite(t *testing.T) {
+	storage := s.newStorage(t)
+
+	mustStore(t, storage, &hamr.Item{Key: "key", Value: "first", Expiration: time.Minute})
+	mustStore(t, storage, &hamr.Item{Key: "key", Value: "second", Expiration: time.Minute})
+
+	assertValue(t, storage, "key", "second")
+}
+
+func (s *suite) testLoadMissing(t *testing.T) {
+	storage := s.newStorage(t)
+
+	assertNotFound(t, storage, "missing")
+}
+
+func (s *suite) testDelete(t *testing.T) {
+	storage := s.newStorage(t)
+
+	mustStore(t, storage,
+		&hamr.Item{Key: "a", Value: "a", Expiration: time.Minute},
+		&hamr.Item{Key: "b", Value: "b", Expiration: time.Minute},
+		&hamr.Item{Key: "c", Value: "c", Expiration: time.Minute},
+	)
+
+	if err := storage.Delete("a", "b"); err != nil {
+		t.Fatalf("Delete(a, b): %v", err)
+	}
+
+	assertNotFound(t, storage, "a")
+	assertNotFound(t, storage, "b")
+	assertValue(t, storage, "c", "c")
+}
+
+func (s *suite) testDeleteMissing(t *testing.T) {
+	storage := s.newStorage(t)
+
+	mustStore(t, storage, &hamr.Item{Key: "a", Value: "a", Expiration: time.Minute})
+
+	if err := storage.Delete("missing", "a"); err != nil {
+		t.Fatalf("Delete with missing key: %v", err)
+	}
+	if err := storage.Delete(); err != nil {
+		t.Fatalf("Delete() without keys: %v", err)
+	}
+
+	assertNotFound(t, storage, "a")
+}
+
+func (s *suite) testExpiration(t *testing.T) {
+	storage := s.newStorage(t)
+
+	mustStore(t, storage,
+		&hamr.Item{Key: "short", Value: "short", Expiration: ttl},
+		&hamr.Item{Key: "long", Value: "long", Expiration: time.Hour},
+		&hamr.Item{Key: "persistent", Value: "persistent"},
+	)
+
+	assertValue(t, storage, "short", "short")
+
+	s.sleep(ttl * 2)
+
+	assertNotFound(t, storage, "short")
+	assertValue(t, storage, "long", "long")
+	assertValue(t, storage, "persistent", "persistent")
+}
+
+func (s *suite) testOverwriteExpiration(t *testing.T) {
+	storage := s.newStorage(t)
+
+	mustStore(t, storage, &hamr.Item{Key: "key", Value: "short", Expiration: ttl})
+	mustStore(t, storage, &hamr.Item{Key: "key", Value: "long", Expiration: time.Hour})
+
+	s.sleep(ttl * 2)
+
+	assertValue(t, storage, "key", "long")
+}
+
+func (s *suite) testConcurrency(t *testing.T) {
+	storage := s.newStorage(t)
+
+	const workers = 8
+	const iterations = 25
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*iterations)
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			for i := 0; i < iterations; i++ {
+				own := fmt.Sprintf("worker-%d-%d", w, i)
+				shared := fmt.Sprintf("shared-%d", i%3)
+
+				if err := storage.Store(
+					&hamr.Item{Key: own, Value: i, Expiration: time.Minute},
+					&hamr.Item{Key: shared, Value: w, Expiration: time.Minute},
+				); err != nil {
+					errs <- fmt.Errorf("Store: %w", err)
+					return
+				}
+
+				if _, err := storage.Load(own); err != nil {
+					errs <- fmt.Errorf("Load(%s): %w", own, err)
+					return
+				}
+
+				if _, err := storage.Load(shared); err != nil && !errors.Is(err, hamr.ErrNotFound) {
+					errs <- fmt.Errorf("Load(%s): %w", shared, err)
+					return
+				}
+
+				if err := storage.Delete(own, shared); err != nil {
+					errs <- fmt.Errorf("Delete: %w", err)
+					return
+				}
+			}
+		}(w)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func mustStore(t *testing.T, storage hamr.TokenStorage, items ...*hamr.Item) {
+	t.Helper()
+
+	if err := storage.Store(items...); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+}
+
+// assertValue will compare loaded value with expected value, both as decoded json.
+func assertValue(t *testing.T, storage hamr.TokenStorage, key string, expected interface{}) {
+	t.Helper()
+
+	loaded, err := storage.Load(key)
+	if err != nil {
+		t.Fatalf("Load(%s): %v", key, err)
+	}
+
+	var got interface{}
+	if err = json.Unmarshal(loaded, &got); err != nil {
+		t.Fatalf("Load(%s) returned invalid json %q: %v", key, loaded, err)
+	}
+
+	expectedBytes, err := json.Marshal(expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want interface{}
+	if err = json.Unmarshal(expectedBytes, &want); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Load(%s) = %s, want %s", key, loaded, expectedBytes)
+	}
+}
+
+func assertNotFound(t *testing.T, storage hamr.TokenStorage, key string) {
+	t.Helper()
+
+	_, err := storage.Load(key)
+	if !errors.Is(err, hamr.ErrNotFound) {
+		t.Fatalf("Load(%s) error = %v, want hamr.ErrNotFound", key, err)
+	}
+}
@@ -0,0 +1,279 @@
+package tiered
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+
+	"github.com/semirm-dev/hamr"
+	"github.com/semirm-dev/hamr/storage/memory"
+)
+
+const defaultLocalTTL = time.Second * 5
+
+// Storage is hamr.TokenStorage wrapper with short-lived in-process read-through cache in front of any backend.
+// Cached items live at most local TTL, so changes made by other instances are visible within that delay,
+// or immediately when Invalidator is used.
+type Storage struct {
+	backend     hamr.ContextTokenStorage
+	local       *memory.Storage
+	negative    *memory.Storage
+	localTTL    time.Duration
+	negativeTTL time.Duration
+	maxEntries  int
+	invalidator Invalidator
+	instanceId  string
+	cancel      context.CancelFunc
+}
+
+// Invalidator propagates cache invalidations between instances, ex. with redis pub/sub.
+type Invalidator interface {
+	// Publish will send message to all subscribed instances, including the publisher.
+	Publish(ctx context.Context, message []byte) error
+	// Subscribe will call handler for each published message until ctx is done. It returns once subscription is active.
+	Subscribe(ctx context.Context, handler func(message []byte)) error
+}
+
+// invalidation is published each time keys are stored or deleted.
+type invalidation struct {
+	Origin string   `json:"origin"`
+	Keys   []string `json:"keys"`
+}
+
+type Option func(*Storage)
+
+// New will wrap backend storage with local cache. Call Close to stop local cache janitors and invalidation subscription.
+// Backend without context support is adapted with hamr.StorageWithContext.
+func New(backend hamr.TokenStorage, opts ...Option) (*Storage, error) {
+	return NewWithContextBackend(hamr.StorageWithContext(backend), opts...)
+}
+
+// NewWithContextBackend is New with context-aware backend storage, used as is.
+func NewWithContextBackend(backend hamr.ContextTokenStorage, opts ...Option) (*Storage, error) {
+	s := &Storage{
+		backend:    backend,
+		localTTL:   defaultLocalTTL,
+		instanceId: uuid.New().String(),
+	}
+
+	for _, o := range opts {
+		o(s)
+	}
+
+	s.local = memory.New(memory.WithMaxEntries(s.maxEntries), memory.WithCleanupInterval(s.localTTL))
+	s.negative = memory.New(memory.WithMaxEntries(s.maxEntries), memory.WithCleanupInterval(s.negativeTTL))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	if s.invalidator != nil {
+		if err := s.invalidator.Subscribe(ctx, s.handleInvalidation); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// WithLocalTTL defines how long loaded items are cached locally. It is the maximum delay for changes made by other instances.
+func WithLocalTTL(ttl time.Duration) Option {
+	return func(s *Storage) {
+		s.localTTL = ttl
+	}
+}
+
+// WithNegativeTTL will cache deleted (revoked) keys locally for given duration, so their loads do not reach backend.
+// Keys which are simply missing in backend are not cached. 0 disables negative cache.
+func WithNegativeTTL(ttl time.Duration) Option {
+	return func(s *Storage) {
+		s.negativeTTL = ttl
+	}
+}
+
+// WithMaxEntries will limit number of locally cached items, least recently used items are evicted.
+func WithMaxEntries(maxEntries int) Option {
+	return func(s *Storage) {
+		s.maxEntries = maxEntries
+	}
+}
+
+// WithInvalidator will propagate stored and deleted keys to other instances, so their local cache is invalidated immediately.
+func WithInvalidator(invalidator Invalidator) Option {
+	return func(s *Storage) {
+		s.invalidator = invalidator
+	}
+}
+
+func (s *Storage) Store(items ...*hamr.Item) error {
+	return s.StoreContext(context.Background(), items...)
+}
+
+func (s *Storage) Load(key string) ([]byte, error) {
+	return s.LoadContext(context.Background(), key)
+}
+
+func (s *Storage) Delete(keys ...string) error {
+	return s.DeleteContext(context.Background(), keys...)
+}
+
+// StoreContext will store items in backend first and then cache them locally.
+func (s *Storage) StoreContext(ctx context.Context, items ...*hamr.Item) error {
+	if err := s.backend.StoreContext(ctx, items...); err != nil {
+		return err
+	}
+
+	keys := make([]string, len(items))
+	localItems := make([]*hamr.Item, len(items))
+	for i, item := range items {
+		itemBytes, err := json.Marshal(item.Value)
+		if err != nil {
+			return err
+		}
+
+		keys[i] = item.Key
+		localItems[i] = &hamr.Item{
+			Key:        item.Key,
+			Value:      json.RawMessage(itemBytes),
+			Expiration: s.localExpiration(item.Expiration),
+		}
+	}
+
+	if err := s.negative.Delete(keys...); err != nil {
+		return err
+	}
+
+	if err := s.local.Store(localItems...); err != nil {
+		return err
+	}
+
+	s.publish(ctx, keys)
+
+	return nil
+}
+
+// LoadContext will load item from local cache, or from backend if it is not cached yet.
+// Keys deleted on this instance are reported missing from negative cache. Contexts marked with hamr.WithNoCache
+// always load from backend, missing keys are never cached.
+func (s *Storage) LoadContext(ctx context.Context, key string) ([]byte, error) {
+	if hamr.NoCache(ctx) {
+		return s.backend.LoadContext(ctx, key)
+	}
+
+	if s.negativeTTL > 0 {
+		if _, err := s.negative.Load(key); err == nil {
+			return nil, hamr.ErrNotFound
+		}
+	}
+
+	if cached, err := s.local.Load(key); err == nil {
+		return cached, nil
+	}
+
+	loaded, err := s.backend.LoadContext(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.local.Store(&hamr.Item{
+		Key:        key,
+		Value:      json.RawMessage(loaded),
+		Expiration: s.localTTL,
+	}); err != nil {
+		return nil, err
+	}
+
+	return loaded, nil
+}
+
+// DeleteContext will delete keys from backend and local cache. Deleted keys are cached as missing if negative cache is enabled.
+func (s *Storage) DeleteContext(ctx context.Context, keys ...string) error {
+	if err := s.backend.DeleteContext(ctx, keys...); err != nil {
+		return err
+	}
+
+	if err := s.local.Delete(keys...); err != nil {
+		return err
+	}
+
+	if err := s.cacheMissing(keys...); err != nil {
+		return err
+	}
+
+	s.publish(ctx, keys)
+
+	return nil
+}
+
+// Close will stop local cache janitors and invalidation subscription.
+func (s *Storage) Close() {
+	s.cancel()
+	s.local.Close()
+	s.negative.Close()
+}
+
+// cacheMissing will save deleted keys in negative cache.
+func (s *Storage) cacheMissing(keys ...string) error {
+	if s.negativeTTL <= 0 || len(keys) == 0 {
+		return nil
+	}
+
+	items := make([]*hamr.Item, len(keys))
+	for i, key := range keys {
+		items[i] = &hamr.Item{
+			Key:        key,
+			Value:      true,
+			Expiration: s.negativeTTL,
+		}
+	}
+
+	return s.negative.Store(items...)
+}
+
+// localExpiration will cache item locally not longer than it lives in backend.
+func (s *Storage) localExpiration(expiration time.Duration) time.Duration {
+	if expiration > 0 && expiration < s.localTTL {
+		return expiration
+	}
+
+	return s.localTTL
+}
+
+// publish will notify other instances about changed keys. Failure is only logged, other instances still catch up after local TTL.
+func (s *Storage) publish(ctx context.Context, keys []string) {
+	if s.invalidator == nil || len(keys) == 0 {
+		return
+	}
+
+	message, err := json.Marshal(&invalidation{
+		Origin: s.instanceId,
+		Keys:   keys,
+	})
+	if err != nil {
+		logrus.Error("failed to marshal cache invalidation: ", err)
+		return
+	}
+
+	if err = s.invalidator.Publish(ctx, message); err != nil {
+		logrus.Error("failed to publish cache invalidation: ", err)
+	}
+}
+
+// handleInvalidation will remove keys changed by other instances from local cache.
+func (s *Storage) handleInvalidation(message []byte) {
+	var inv invalidation
+	if err := json.Unmarshal(message, &inv); err != nil {
+		logrus.Error("failed to unmarshal cache invalidation: ", err)
+		return
+	}
+
+	if inv.Origin == s.instanceId {
+		return
+	}
+
+	_ = s.local.Delete(inv.Keys...)
+	_ = s.negative.Delete(inv.Keys...)
+}
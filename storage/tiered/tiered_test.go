@@ -0,0 +1,168 @@
+package tiered_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/redis/go-redis/v9"
+
+	"github.com/semirm-dev/hamr"
+	"github.com/semirm-dev/hamr/storage/memory"
+	"github.com/semirm-dev/hamr/storage/redis"
+	"github.com/semirm-dev/hamr/storage/storagetest"
+	"github.com/semirm-dev/hamr/storage/tiered"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) hamr.TokenStorage {
+		backend := memory.New()
+		t.Cleanup(backend.Close)
+
+		s, err := tiered.New(backend, tiered.WithNegativeTTL(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(s.Close)
+
+		return s
+	})
+}
+
+func TestStorage_ContextBackend(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) hamr.TokenStorage {
+		backend := memory.New()
+		t.Cleanup(backend.Close)
+
+		// backend implementing only hamr.ContextTokenStorage
+		s, err := tiered.NewWithContextBackend(struct{ hamr.ContextTokenStorage }{hamr.StorageWithContext(backend)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(s.Close)
+
+		return s
+	})
+}
+
+func TestStorage_NegativeCache(t *testing.T) {
+	backend := memory.New()
+	defer backend.Close()
+
+	s, err := tiered.New(backend, tiered.WithNegativeTTL(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if _, err = s.Load("missing"); !errors.Is(err, hamr.ErrNotFound) {
+		t.Fatalf("Load() error = %v, want hamr.ErrNotFound", err)
+	}
+
+	if err = s.Store(&hamr.Item{Key: "revoked", Value: "value"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Delete("revoked"); err != nil {
+		t.Fatal(err)
+	}
+
+	// stored directly in backend, bypassing local cache
+	if err = backend.Store(&hamr.Item{Key: "missing", Value: "value"}, &hamr.Item{Key: "revoked", Value: "value"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = s.Load("missing"); err != nil {
+		t.Fatalf("Load() of key missing before error = %v, want it loaded from backend", err)
+	}
+
+	if _, err = s.Load("revoked"); !errors.Is(err, hamr.ErrNotFound) {
+		t.Fatalf("Load() of deleted key error = %v, want cached hamr.ErrNotFound", err)
+	}
+}
+
+func TestStorage_NoCache(t *testing.T) {
+	backend := memory.New()
+	defer backend.Close()
+
+	s, err := tiered.New(backend, tiered.WithLocalTTL(time.Hour), tiered.WithNegativeTTL(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err = s.Store(&hamr.Item{Key: "cached", Value: "old"}, &hamr.Item{Key: "revoked", Value: "old"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Delete("revoked"); err != nil {
+		t.Fatal(err)
+	}
+
+	// changed by other instance
+	if err = backend.Store(&hamr.Item{Key: "cached", Value: "new"}, &hamr.Item{Key: "revoked", Value: "new"}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := hamr.WithNoCache(context.Background())
+	for _, key := range []string{"cached", "revoked"} {
+		loaded, err := s.LoadContext(ctx, key)
+		if err != nil {
+			t.Fatalf("LoadContext(%s) error = %v", key, err)
+		}
+		if string(loaded) != `"new"` {
+			t.Errorf("LoadContext(%s) = %s, want value from backend", key, loaded)
+		}
+	}
+}
+
+func TestStorage_Invalidation(t *testing.T) {
+	m := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: m.Addr()})
+	defer func() {
+		_ = client.Close()
+	}()
+
+	backend := redis.NewWithClient(client, "test:")
+
+	var instances []*tiered.Storage
+	for i := 0; i < 2; i++ {
+		s, err := tiered.New(backend,
+			tiered.WithLocalTTL(time.Hour),
+			tiered.WithNegativeTTL(time.Hour),
+			tiered.WithInvalidator(redis.NewInvalidator(client, "test:invalidations")),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+
+		instances = append(instances, s)
+	}
+
+	if err := instances[0].Store(&hamr.Item{Key: "key", Value: "value", Expiration: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+
+	// cache on the second instance
+	if _, err := instances[1].Load("key"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := instances[0].Delete("key"); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second * 2)
+	for {
+		_, err := instances[1].Load("key")
+		if errors.Is(err, hamr.ErrNotFound) {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("revoked key is still cached on other instance, Load() error = %v", err)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}